@@ -0,0 +1,137 @@
+package lfsm
+
+import "sort"
+
+// Analysis describes the shape of a Constraints graph, see Analyze.
+// All the state lists are sorted.
+type Analysis struct {
+	// States holds every state that appears in the constraints, as a source or as a destination.
+	States []uint32
+	// Reachable holds the states that can be reached from the initial state (including the initial state).
+	Reachable []uint32
+	// Unreachable holds the states that cannot be reached from the initial state.
+	Unreachable []uint32
+	// Terminal holds the states without outgoing transitions (sink states).
+	Terminal []uint32
+	// Undeclared holds the states that are referenced as destinations or as the initial state, but are missing as
+	// sources.
+	Undeclared []uint32
+	// Components holds the strongly connected components of the graph, in reverse topological order.
+	Components [][]uint32
+}
+
+// Analyze inspects the transitions graph of m, starting from the initial state.
+func Analyze(m Constraints, initial uint32) Analysis {
+	var a Analysis
+
+	seen := map[uint32]bool{initial: true}
+	undeclared := map[uint32]bool{}
+	if _, ok := m[initial]; !ok {
+		undeclared[initial] = true
+		a.Undeclared = append(a.Undeclared, initial)
+	}
+	for src, dsts := range m {
+		seen[src] = true
+		for _, dst := range dsts {
+			if _, ok := m[dst]; !ok && !undeclared[dst] {
+				undeclared[dst] = true
+				a.Undeclared = append(a.Undeclared, dst)
+			}
+			seen[dst] = true
+		}
+	}
+	for v := range seen {
+		a.States = append(a.States, v)
+		if len(m[v]) == 0 {
+			a.Terminal = append(a.Terminal, v)
+		}
+	}
+
+	reachable := map[uint32]bool{initial: true}
+	queue := []uint32{initial}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, dst := range m[v] {
+			if !reachable[dst] {
+				reachable[dst] = true
+				queue = append(queue, dst)
+			}
+		}
+	}
+	for v := range seen {
+		if reachable[v] {
+			a.Reachable = append(a.Reachable, v)
+		} else {
+			a.Unreachable = append(a.Unreachable, v)
+		}
+	}
+
+	sortStates(a.States)
+	sortStates(a.Reachable)
+	sortStates(a.Unreachable)
+	sortStates(a.Terminal)
+	sortStates(a.Undeclared)
+	a.Components = components(m, a.States)
+	return a
+}
+
+// components finds the strongly connected components of m using Tarjan's algorithm.
+func components(m Constraints, states []uint32) [][]uint32 {
+	var (
+		index    = make(map[uint32]int, len(states))
+		low      = make(map[uint32]int, len(states))
+		onStack  = make(map[uint32]bool, len(states))
+		stack    []uint32
+		result   [][]uint32
+		connect  func(v uint32)
+		sortedTo = func(v uint32) []uint32 {
+			dsts := append([]uint32(nil), m[v]...)
+			sortStates(dsts)
+			return dsts
+		}
+	)
+	connect = func(v uint32) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, dst := range sortedTo(v) {
+			if _, ok := index[dst]; !ok {
+				connect(dst)
+				if low[dst] < low[v] {
+					low[v] = low[dst]
+				}
+			} else if onStack[dst] && index[dst] < low[v] {
+				low[v] = index[dst]
+			}
+		}
+
+		if low[v] == index[v] {
+			var component []uint32
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			sortStates(component)
+			result = append(result, component)
+		}
+	}
+
+	for _, v := range states {
+		if _, ok := index[v]; !ok {
+			connect(v)
+		}
+	}
+	return result
+}
+
+func sortStates(states []uint32) {
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+}
@@ -0,0 +1,267 @@
+/*
+Command lfsmgen generates typed state constants, their String method, the Constraints, the StateNames and a
+constructor from a state machine definition (see lfsm.Definition).
+
+Usage:
+
+	//go:generate go run github.com/Eyal-Shalev/lfsm/cmd/lfsmgen -spec order.json -type Order
+
+Given the definition:
+
+	{
+		"states": [{"id": 0, "name": "creating"}, {"id": 1, "name": "paid"}],
+		"initial": "creating",
+		"transitions": {"creating": ["paid"]},
+		"final": ["paid"]
+	}
+
+lfsmgen writes order_lfsm.go, that declares the Order type, the OrderCreating and OrderPaid constants, the
+OrderConstraints and OrderStateNames variables and the NewOrderMachine constructor, that returns a
+*lfsm.Machine[Order].
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/Eyal-Shalev/lfsm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("lfsmgen: ")
+
+	spec := flag.String("spec", "", "path of the JSON state machine definition")
+	typeName := flag.String("type", "", "name of the generated state type")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package name of the generated file")
+	output := flag.String("o", "", "output file (default <type>_lfsm.go)")
+	flag.Parse()
+
+	if *spec == "" || *typeName == "" || *pkg == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.ToLower(*typeName) + "_lfsm.go"
+	}
+
+	f, err := os.Open(*spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	d, err := lfsm.ParseDefinition(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(d, *pkg, *typeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type state struct {
+	ID    uint32
+	Name  string
+	Const string
+}
+
+type machine struct {
+	Package, Type string
+	States        []state
+	Initial       string
+	Transitions   map[string][]string
+	Events        map[string]map[string]string
+	Substates     map[string][]string
+	Final         []string
+}
+
+// generate validates the definition, and renders the formatted Go source of the state machine.
+func generate(d *lfsm.Definition, pkg, typeName string) ([]byte, error) {
+	s, err := d.NewState()
+	if err != nil {
+		return nil, err
+	}
+	// The exported definition is canonical: states are referenced by name, or by their integer if they have no name.
+	d = s.Definition()
+
+	m := &machine{
+		Package:     pkg,
+		Type:        typeName,
+		Transitions: make(map[string][]string),
+		Events:      make(map[string]map[string]string),
+		Substates:   make(map[string][]string),
+	}
+
+	ids := make(map[string]uint32)
+	for _, sd := range d.States {
+		ids[sd.Name] = sd.ID
+	}
+	consts := make(map[uint32]string)
+	used := make(map[string]bool)
+	ref := func(r string) string {
+		id, ok := ids[r]
+		if !ok {
+			n, _ := strconv.ParseUint(r, 10, 32)
+			id = uint32(n)
+		}
+		if c, ok := consts[id]; ok {
+			return c
+		}
+		c := typeName + identifier(r)
+		for i := 1; used[c] || c == typeName; i++ {
+			// The fallback may collide with a name too (e.g. a state named "7"), so a counter is added until it's unique.
+			c = typeName + strconv.Itoa(int(id))
+			if i > 1 {
+				c += "_" + strconv.Itoa(i)
+			}
+		}
+		name := r
+		if _, ok := ids[r]; !ok {
+			name = ""
+		}
+		consts[id] = c
+		used[c] = true
+		m.States = append(m.States, state{id, name, c})
+		return c
+	}
+	refs := func(rs []string) []string {
+		cs := make([]string, len(rs))
+		for i, r := range rs {
+			cs[i] = ref(r)
+		}
+		return cs
+	}
+
+	for _, sd := range d.States {
+		ref(sd.Name)
+	}
+	m.Initial = ref(d.Initial)
+	for src, dsts := range d.Transitions {
+		m.Transitions[ref(src)] = refs(dsts)
+	}
+	for src, byEvent := range d.Events {
+		m.Events[ref(src)] = make(map[string]string)
+		for event, dst := range byEvent {
+			m.Events[ref(src)][event] = ref(dst)
+		}
+	}
+	for parent, children := range d.Substates {
+		m.Substates[ref(parent)] = refs(children)
+	}
+	m.Final = refs(d.Final)
+	sort.Slice(m.States, func(i, j int) bool { return m.States[i].ID < m.States[j].ID })
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, m); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// identifier converts a state name to an exported Go identifier suffix, e.g. "await from" to "AwaitFrom".
+func identifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var tmpl = template.Must(template.New("").Parse(`// Code generated by lfsmgen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"strconv"
+
+	"github.com/Eyal-Shalev/lfsm"
+)
+
+// {{.Type}} is a state of the {{.Type}} state machine.
+type {{.Type}} uint32
+
+const (
+{{- range .States}}
+	{{.Const}} {{$.Type}} = {{.ID}}
+{{- end}}
+)
+
+// String returns the name of the state.
+func (v {{.Type}}) String() string {
+	switch v {
+{{- range .States}}{{if .Name}}
+	case {{.Const}}:
+		return {{printf "%q" .Name}}
+{{- end}}{{end}}
+	}
+	return "{{.Type}}(" + strconv.FormatUint(uint64(v), 10) + ")"
+}
+
+// {{.Type}}Constraints defines the possible transitions of the {{.Type}} state machine.
+var {{.Type}}Constraints = lfsm.TypedConstraints[{{.Type}}]{
+{{- range $src, $dsts := .Transitions}}
+	{{$src}}: { {{- range $i, $dst := $dsts}}{{if $i}}, {{end}}{{$dst}}{{end -}} },
+{{- end}}
+}
+
+// {{.Type}}StateNames holds the names of the {{.Type}} states.
+var {{.Type}}StateNames = lfsm.TypedStateNames[{{.Type}}]{
+{{- range .States}}{{if .Name}}
+	{{.Const}}: {{printf "%q" .Name}},
+{{- end}}{{end}}
+}
+{{- if .Events}}
+
+// {{.Type}}Events maps the {{.Type}} states to their events.
+var {{.Type}}Events = lfsm.TypedEvents[{{.Type}}]{
+{{- range $src, $events := .Events}}
+	{{$src}}: {
+{{- range $event, $dst := $events}}
+		{{printf "%q" $event}}: {{$dst}},
+{{- end}}
+	},
+{{- end}}
+}
+{{- end}}
+
+// New{{.Type}}Machine creates a new {{.Type}} state machine.
+func New{{.Type}}Machine() *lfsm.Machine[{{.Type}}] {
+	return lfsm.NewMachine(
+		{{.Type}}Constraints,
+		lfsm.TypedInitialState({{.Initial}}),
+		{{.Type}}StateNames,
+{{- if .Events}}
+		{{.Type}}Events,
+{{- end}}
+{{- range $parent, $children := .Substates}}
+		lfsm.TypedSubstates({{$parent}}{{range $children}}, {{.}}{{end}}),
+{{- end}}
+{{- if .Final}}
+		lfsm.TypedFinalStates({{range $i, $v := .Final}}{{if $i}}, {{end}}{{$v}}{{end}}),
+{{- end}}
+	)
+}
+`))
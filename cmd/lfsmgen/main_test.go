@@ -0,0 +1,89 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/Eyal-Shalev/lfsm"
+)
+
+func TestGenerate(t *testing.T) {
+	d, err := lfsm.ParseDefinition(strings.NewReader(`{
+		"states": [{"id": 0, "name": "idle"}, {"id": 1, "name": "await from"}],
+		"initial": "await from",
+		"transitions": {"idle": ["await from"], "await from": ["idle", "2"]},
+		"final": ["2"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(d, "bank", "Transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"TransferIdle      Transfer = 0",
+		"TransferAwaitFrom Transfer = 1",
+		"Transfer2         Transfer = 2",
+		`return "await from"`,
+		"TransferAwaitFrom: {TransferIdle, Transfer2},",
+		"lfsm.TypedInitialState(TransferAwaitFrom),",
+		"lfsm.TypedFinalStates(Transfer2),",
+		"func NewTransferMachine() *lfsm.Machine[Transfer] {",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("expected the generated source to contain %q:\n%s", want, src)
+		}
+	}
+	typeCheck(t, src)
+}
+
+func TestGenerateNameCollisions(t *testing.T) {
+	d, err := lfsm.ParseDefinition(strings.NewReader(`{
+		"states": [{"id": 0, "name": "#1"}, {"id": 1, "name": "1!"}, {"id": 2, "name": "a b"}, {"id": 3, "name": "a-b"}, {"id": 4, "name": "!"}],
+		"initial": "#1",
+		"transitions": {"#1": ["1!", "a b"], "a b": ["a-b"], "a-b": ["!"]},
+		"events": {"a b": {"go": "a-b"}},
+		"substates": {"a-b": ["!"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(d, "bank", "Transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Transfer1   Transfer = 0", "Transfer1_2 Transfer = 1", "Transfer3   Transfer = 3", "Transfer4   Transfer = 4"} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("expected the generated source to contain %q:\n%s", want, src)
+		}
+	}
+	typeCheck(t, src)
+}
+
+// typeCheck fails the test if the generated source doesn't compile.
+func typeCheck(t *testing.T, src []byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "transfer_lfsm.go", src, 0)
+	if err != nil {
+		t.Fatalf("invalid generated source: %s\n%s", err, src)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("bank", fset, []*ast.File{f}, nil); err != nil {
+		t.Errorf("generated source doesn't type-check: %s\n%s", err, src)
+	}
+}
+
+func TestGenerateInvalidDefinition(t *testing.T) {
+	d := &lfsm.Definition{Initial: "7", Transitions: map[string][]string{"0": {"1"}}}
+	if _, err := generate(d, "bank", "Transfer"); err == nil {
+		t.Error("expected an invalid definition error")
+	}
+}
@@ -0,0 +1,205 @@
+package lfsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var (
+	// ErrDuplicateStateID reports a state that is defined more than once in a Definition.
+	ErrDuplicateStateID = errors.New("duplicate state id")
+	// ErrAmbiguousReference reports a state name that is also the integer of another state, so references to it are
+	// ambiguous.
+	ErrAmbiguousReference = errors.New("ambiguous state reference")
+)
+
+// Definition is a declarative description of a state machine.
+//
+// ParseDefinition and LoadDefinition only decode JSON, to keep this package free of dependencies. The fields are also
+// tagged for YAML and TOML decoders, so definitions in these formats can be decoded into a Definition by the decoder of
+// your choice, and then passed to Definition.NewState.
+//
+// States are referenced by their name, or by their integer (in its string form) if they have no name. A state name
+// that is the integer of a different state is rejected, since references to it would be ambiguous.
+type Definition struct {
+	// States assigns names to state integers.
+	States []StateDefinition `json:"states,omitempty" yaml:"states,omitempty" toml:"states,omitempty"`
+	// Initial is the initial state, it defaults to the 0 state.
+	Initial string `json:"initial,omitempty" yaml:"initial,omitempty" toml:"initial,omitempty"`
+	// Transitions maps source states to their valid destinations, see Constraints.
+	Transitions map[string][]string `json:"transitions" yaml:"transitions" toml:"transitions"`
+	// Events maps source states to their events and destinations, see Events.
+	Events map[string]map[string]string `json:"events,omitempty" yaml:"events,omitempty" toml:"events,omitempty"`
+	// Substates maps composite states to their substates, see Substates.
+	Substates map[string][]string `json:"substates,omitempty" yaml:"substates,omitempty" toml:"substates,omitempty"`
+	// Final lists the final states, see FinalStates.
+	Final []string `json:"final,omitempty" yaml:"final,omitempty" toml:"final,omitempty"`
+}
+
+// StateDefinition names a single state of a Definition.
+type StateDefinition struct {
+	ID   uint32 `json:"id" yaml:"id" toml:"id"`
+	Name string `json:"name" yaml:"name" toml:"name"`
+}
+
+// ParseDefinition decodes a JSON Definition from r.
+func ParseDefinition(r io.Reader) (*Definition, error) {
+	d := new(Definition)
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("lfsm: invalid definition: %w", err)
+	}
+	return d, nil
+}
+
+// LoadDefinition decodes a JSON Definition from r, and creates a new State Machine from it.
+func LoadDefinition(r io.Reader) (*State, error) {
+	d, err := ParseDefinition(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.NewState()
+}
+
+// NewState creates a new State Machine from the definition.
+// The configuration is validated like in NewStateE.
+func (d *Definition) NewState() (*State, error) {
+	names := make(StateNames, len(d.States))
+	for _, sd := range d.States {
+		if _, ok := names[sd.ID]; ok {
+			return nil, fmt.Errorf("lfsm: %w %d", ErrDuplicateStateID, sd.ID)
+		}
+		names[sd.ID] = sd.Name
+	}
+	for _, sd := range d.States {
+		if v, err := strconv.ParseUint(sd.Name, 10, 32); err == nil && uint32(v) != sd.ID {
+			return nil, fmt.Errorf("lfsm: %w %q (state %d)", ErrAmbiguousReference, sd.Name, sd.ID)
+		}
+	}
+	resolve := func(ref string) (uint32, error) {
+		if v, ok := names.lookup(ref); ok {
+			return v, nil
+		}
+		v, err := strconv.ParseUint(ref, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("lfsm: %w %q", ErrUnknownState, ref)
+		}
+		return uint32(v), nil
+	}
+	resolveAll := func(refs []string) ([]uint32, error) {
+		vs := make([]uint32, len(refs))
+		for i, ref := range refs {
+			v, err := resolve(ref)
+			if err != nil {
+				return nil, err
+			}
+			vs[i] = v
+		}
+		return vs, nil
+	}
+
+	opts := []option{names}
+	if d.Initial != "" {
+		v, err := resolve(d.Initial)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, InitialState(v))
+	}
+
+	m := make(Constraints, len(d.Transitions))
+	for src, dsts := range d.Transitions {
+		v, err := resolve(src)
+		if err != nil {
+			return nil, err
+		}
+		if m[v], err = resolveAll(dsts); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(d.Events) > 0 {
+		events := make(Events, len(d.Events))
+		for src, byEvent := range d.Events {
+			v, err := resolve(src)
+			if err != nil {
+				return nil, err
+			}
+			events[v] = make(map[string]uint32, len(byEvent))
+			for event, dst := range byEvent {
+				if events[v][event], err = resolve(dst); err != nil {
+					return nil, err
+				}
+			}
+		}
+		opts = append(opts, events)
+	}
+
+	for parent, children := range d.Substates {
+		v, err := resolve(parent)
+		if err != nil {
+			return nil, err
+		}
+		vs, err := resolveAll(children)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, Substates(v, vs...))
+	}
+
+	if len(d.Final) > 0 {
+		vs, err := resolveAll(d.Final)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, FinalStates(vs...))
+	}
+
+	return NewStateE(m, opts...)
+}
+
+// Definition exports the configuration of this state machine, so it can be encoded and later loaded again.
+// Callbacks, guards and other options that cannot be declared are not exported.
+func (s *State) Definition() *Definition {
+	d := &Definition{
+		Initial:     s.stateNames.find(s.initial),
+		Transitions: make(map[string][]string, len(s.transitions)),
+	}
+	for _, v := range sortedKeys(s.stateNames) {
+		d.States = append(d.States, StateDefinition{v, s.stateNames[v]})
+	}
+	for _, src := range sortedKeys(s.transitions) {
+		d.Transitions[s.stateNames.find(src)] = s.refs(sortedKeys(s.transitions[src]))
+	}
+	if len(s.events) > 0 {
+		d.Events = make(map[string]map[string]string, len(s.events))
+		for src, byEvent := range s.events {
+			d.Events[s.stateNames.find(src)] = make(map[string]string, len(byEvent))
+			for event, dst := range byEvent {
+				d.Events[s.stateNames.find(src)][event] = s.stateNames.find(dst)
+			}
+		}
+	}
+	if len(s.parents) > 0 {
+		d.Substates = make(map[string][]string)
+		for parent, children := range s.children() {
+			d.Substates[s.stateNames.find(parent)] = s.refs(children)
+		}
+	}
+	if len(s.final) > 0 {
+		d.Final = s.refs(sortedKeys(s.final))
+	}
+	return d
+}
+
+func (s *State) refs(vs []uint32) []string {
+	refs := make([]string, len(vs))
+	for i, v := range vs {
+		refs[i] = s.stateNames.find(v)
+	}
+	return refs
+}
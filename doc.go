@@ -50,5 +50,38 @@ Named Example:
 		}
 		log.Printf("Current state: %s", s.Current()) // Current state: opened
 	}
+
+If your states have their own type, lfsm.NewMachine can be used to let the compiler verify that only states of that
+type are passed to the state machine, including its options (see lfsm.MachineOption).
+Typed Example:
+	package main
+
+	import (
+		"log"
+		"github.com/Eyal-Shalev/lfsm"
+	)
+
+	type door uint32
+
+	const (
+		opened door = iota
+		closed
+	)
+
+	func main() {
+		m := lfsm.NewMachine(
+			lfsm.TypedConstraints[door]{
+				opened: {closed},
+				closed: {opened},
+			},
+			lfsm.TypedInitialState(closed),
+			lfsm.TypedStateNames[door]{opened: "opened", closed: "closed"},
+		)
+
+		if err := m.Transition(opened); err != nil {
+			panic(err)
+		}
+		log.Printf("Current state: %s", m.CurrentName()) // Current state: opened
+	}
 */
 package lfsm
@@ -1,20 +1,55 @@
 package lfsm
 
 import (
+	"errors"
 	"fmt"
 )
 
+// The kinds of failed transition attempts, they are matched by the errors of this package using errors.Is.
+var (
+	// ErrInvalidTransition reports a transition that is not defined in the Constraints.
+	ErrInvalidTransition = errors.New("invalid transition")
+	// ErrStaleSource reports that the current state differs from the transition source state.
+	ErrStaleSource = errors.New("stale source state")
+	// ErrUndefinedEvent reports an event that is not defined for the current state, it also matches
+	// ErrInvalidTransition.
+	ErrUndefinedEvent = errors.New("undefined event")
+	// ErrGuardRejected reports a transition that was vetoed by a Guard.
+	ErrGuardRejected = errors.New("transition rejected")
+	// ErrFinalState reports a transition attempt out of a final state.
+	ErrFinalState = errors.New("final state")
+	// ErrStaleSnapshot reports that the state machine changed since the snapshot that the transition was attempted from.
+	ErrStaleSnapshot = errors.New("stale snapshot")
+)
+
 // TransitionError is an error struct for all failed transition attempts.
+//
+// Its kind is matched with errors.Is, using ErrInvalidTransition, ErrStaleSource or ErrUndefinedEvent.
 type TransitionError struct {
-	Src, Dst   uint32
+	Src, Dst uint32
+	// Event is the name of the event that triggered the transition, it is empty for direct transitions.
+	Event string
+	// Observed is the state that won the compare-and-swap race, it is only valid if ObservedState reports so.
+	Observed uint32
+	// Err is the underlying cause of the failure, if any.
+	Err        error
 	stateNames StateNames
 	msg        string
+	kind       error
+	observed   bool
 }
 func (f *TransitionError) SrcName() string {
 	return f.stateNames.find(f.Src)
 }
 func (f *TransitionError) DstName() string {
-	return f.stateNames.find(f.Src)
+	return f.stateNames.find(f.Dst)
+}
+func (f *TransitionError) ObservedName() string {
+	return f.stateNames.find(f.Observed)
+}
+// ObservedState returns the Observed state, and whether it was recorded (see NewStaleSourceError).
+func (f *TransitionError) ObservedState() (uint32, bool) {
+	return f.Observed, f.observed
 }
 func (f *TransitionError) Error() string {
 	if f.msg != "" {
@@ -22,23 +57,250 @@ func (f *TransitionError) Error() string {
 	}
 	return fmt.Sprintf("transition failed (%s -> %s)", f.SrcName(), f.DstName())
 }
+func (f *TransitionError) Is(target error) bool {
+	return target == f.kind || (target == ErrInvalidTransition && f.kind == ErrUndefinedEvent)
+}
+func (f *TransitionError) Unwrap() error {
+	return f.Err
+}
+
+// outcome returns the Outcome of the failed transition attempt, according to its kind.
+func (f *TransitionError) outcome() Outcome {
+	if f.kind == ErrStaleSource {
+		return OutcomeFailed
+	}
+	if f.Err != nil {
+		return outcomeOf(f.Err)
+	}
+	return OutcomeInvalid
+}
 
 // NewFailedTransitionError reports that the current state differs from the transition source state.
+//
+// Use NewStaleSourceError to also report the state that was observed instead.
 func NewFailedTransitionError(src, dst uint32, stateNames StateNames) *TransitionError {
 	return &TransitionError{
 		src,
 		dst,
+		"",
+		0,
+		nil,
 		stateNames,
 		fmt.Sprintf("transition failed (%s -> %s) current state is not %s", stateNames.find(src), stateNames.find(dst), stateNames.find(src)),
+		ErrStaleSource,
+		false,
 	}
 }
 
+// NewStaleSourceError reports that the current state is observed, instead of the transition source state.
+func NewStaleSourceError(src, dst, observed uint32, stateNames StateNames) *TransitionError {
+	tErr := NewFailedTransitionError(src, dst, stateNames)
+	tErr.Observed = observed
+	tErr.observed = true
+	return tErr
+}
+
 // NewFailedTransitionError reports about a transition attempt that was not defined in the Constraints map.
 func NewInvalidTransitionError(src, dst uint32, stateNames StateNames) *TransitionError {
 	return &TransitionError{
 		src,
 		dst,
+		"",
+		0,
+		nil,
 		stateNames,
 		fmt.Sprintf("invalid transition (%s -> %s)", stateNames.find(src), stateNames.find(dst)),
+		ErrInvalidTransition,
+		false,
 	}
-}
\ No newline at end of file
+}
+
+// NewUndefinedEventError reports that event is not defined for the src state.
+func NewUndefinedEventError(src uint32, event string, stateNames StateNames) *TransitionError {
+	return &TransitionError{
+		src,
+		src,
+		event,
+		0,
+		nil,
+		stateNames,
+		fmt.Sprintf("event %s: undefined in state %s", event, stateNames.find(src)),
+		ErrUndefinedEvent,
+		false,
+	}
+}
+
+// NewEventError reports that the transition from src to dst, that was triggered by event, failed because of err.
+//
+// The returned error wraps err, so it can be matched using errors.Is and errors.As.
+func NewEventError(src, dst uint32, event string, err error, stateNames StateNames) *TransitionError {
+	return &TransitionError{
+		src,
+		dst,
+		event,
+		0,
+		err,
+		stateNames,
+		fmt.Sprintf("event %s: %s", event, err),
+		nil,
+		false,
+	}
+}
+
+// FinalStateError reports a transition attempt out of a final state.
+type FinalStateError struct {
+	Src, Dst   uint32
+	stateNames StateNames
+}
+func (f *FinalStateError) SrcName() string {
+	return f.stateNames.find(f.Src)
+}
+func (f *FinalStateError) DstName() string {
+	return f.stateNames.find(f.Dst)
+}
+func (f *FinalStateError) Error() string {
+	return fmt.Sprintf("transition failed (%s -> %s) %s is a final state", f.SrcName(), f.DstName(), f.SrcName())
+}
+func (f *FinalStateError) Is(target error) bool {
+	return target == ErrFinalState
+}
+
+// NewFinalStateError reports that src is a final state, so the transition to dst is not possible.
+func NewFinalStateError(src, dst uint32, stateNames StateNames) *FinalStateError {
+	return &FinalStateError{
+		src,
+		dst,
+		stateNames,
+	}
+}
+
+// StaleSnapshotError reports that the state machine changed since the snapshot that the transition was attempted from.
+type StaleSnapshotError struct {
+	Snapshot Snapshot
+	Dst      uint32
+	// Observed is the snapshot of the state machine when the transition failed.
+	Observed   Snapshot
+	stateNames StateNames
+}
+func (f *StaleSnapshotError) Error() string {
+	return fmt.Sprintf(
+		"transition failed (%s -> %s) state changed since generation %d (current state is %s at generation %d)",
+		f.stateNames.find(f.Snapshot.State), f.stateNames.find(f.Dst), f.Snapshot.Generation,
+		f.stateNames.find(f.Observed.State), f.Observed.Generation,
+	)
+}
+func (f *StaleSnapshotError) Is(target error) bool {
+	return target == ErrStaleSnapshot
+}
+
+// NewStaleSnapshotError reports that the state machine is no longer at the snapshot, but at observed.
+func NewStaleSnapshotError(snap Snapshot, dst uint32, observed Snapshot, stateNames StateNames) *StaleSnapshotError {
+	return &StaleSnapshotError{
+		snap,
+		dst,
+		observed,
+		stateNames,
+	}
+}
+
+// TransitionError64 is the 64 bit variant of TransitionError.
+//
+// State64 has no events, guards or final states, so unlike TransitionError it has no Event and no underlying cause.
+type TransitionError64 struct {
+	Src, Dst uint64
+	// Observed is the state that won the compare-and-swap race, it is only valid if ObservedState reports so.
+	Observed   uint64
+	stateNames StateNames64
+	msg        string
+	kind       error
+	observed   bool
+}
+func (f *TransitionError64) SrcName() string {
+	return f.stateNames.find(f.Src)
+}
+func (f *TransitionError64) DstName() string {
+	return f.stateNames.find(f.Dst)
+}
+func (f *TransitionError64) ObservedName() string {
+	return f.stateNames.find(f.Observed)
+}
+
+// ObservedState returns the Observed state, and whether it was recorded (see NewStaleSourceError64).
+func (f *TransitionError64) ObservedState() (uint64, bool) {
+	return f.Observed, f.observed
+}
+func (f *TransitionError64) Error() string {
+	if f.msg != "" {
+		return f.msg
+	}
+	return fmt.Sprintf("transition failed (%s -> %s)", f.SrcName(), f.DstName())
+}
+func (f *TransitionError64) Is(target error) bool {
+	return target == f.kind
+}
+
+// NewFailedTransitionError64 is the 64 bit variant of NewFailedTransitionError.
+func NewFailedTransitionError64(src, dst uint64, stateNames StateNames64) *TransitionError64 {
+	return &TransitionError64{
+		src,
+		dst,
+		0,
+		stateNames,
+		fmt.Sprintf("transition failed (%s -> %s) current state is not %s", stateNames.find(src), stateNames.find(dst), stateNames.find(src)),
+		ErrStaleSource,
+		false,
+	}
+}
+
+// NewStaleSourceError64 is the 64 bit variant of NewStaleSourceError.
+func NewStaleSourceError64(src, dst, observed uint64, stateNames StateNames64) *TransitionError64 {
+	tErr := NewFailedTransitionError64(src, dst, stateNames)
+	tErr.Observed = observed
+	tErr.observed = true
+	return tErr
+}
+
+// NewInvalidTransitionError64 is the 64 bit variant of NewInvalidTransitionError.
+func NewInvalidTransitionError64(src, dst uint64, stateNames StateNames64) *TransitionError64 {
+	return &TransitionError64{
+		src,
+		dst,
+		0,
+		stateNames,
+		fmt.Sprintf("invalid transition (%s -> %s)", stateNames.find(src), stateNames.find(dst)),
+		ErrInvalidTransition,
+		false,
+	}
+}
+
+// GuardRejectedError reports that a Guard vetoed the transition.
+type GuardRejectedError struct {
+	Src, Dst   uint32
+	Err        error
+	stateNames StateNames
+}
+func (f *GuardRejectedError) SrcName() string {
+	return f.stateNames.find(f.Src)
+}
+func (f *GuardRejectedError) DstName() string {
+	return f.stateNames.find(f.Dst)
+}
+func (f *GuardRejectedError) Error() string {
+	return fmt.Sprintf("transition rejected (%s -> %s): %s", f.SrcName(), f.DstName(), f.Err)
+}
+func (f *GuardRejectedError) Is(target error) bool {
+	return target == ErrGuardRejected
+}
+func (f *GuardRejectedError) Unwrap() error {
+	return f.Err
+}
+
+// NewGuardRejectedError reports that a guard rejected the transition with err.
+func NewGuardRejectedError(src, dst uint32, err error, stateNames StateNames) *GuardRejectedError {
+	return &GuardRejectedError{
+		src,
+		dst,
+		err,
+		stateNames,
+	}
+}
@@ -0,0 +1,56 @@
+package lfsm
+
+import "fmt"
+
+// Events maps source states to the events they accept, and each event to its destination state.
+//
+// Events can be passed to NewState as an option, and every (src, event) -> dst entry also adds src -> dst to the
+// transitions of the state machine, so it doesn't have to be repeated in the Constraints.
+type Events map[uint32]map[string]uint32
+
+func (m Events) apply(s *State) {
+	if s.events == nil {
+		s.events = make(Events, len(m))
+	}
+	for src, events := range m {
+		if s.events[src] == nil {
+			s.events[src] = make(map[string]uint32, len(events))
+		}
+		if s.transitions[src] == nil {
+			s.transitions[src] = make(map[uint32]bool)
+		}
+		for event, dst := range events {
+			s.events[src][event] = dst
+			s.transitions[src][dst] = true
+		}
+	}
+}
+
+// Fire tries to change the state using the destination that event maps to from the current state (or from its closest
+// ancestor that defines the event, see Substates).
+// Returns a *TransitionError (with the event name) if the event is not defined for the current state, or if the
+// transition failed. Errors that are not a *TransitionError (such as a *GuardRejectedError) are wrapped, see
+// NewEventError.
+func (s *State) Fire(event string) error {
+	src := s.Current()
+	dst, ok := s.events[src][event]
+	for _, a := range s.ancestry(src)[1:] {
+		if ok {
+			break
+		}
+		dst, ok = s.events[a][event]
+	}
+	if !ok {
+		return s.run(attempt{src: src, dst: src, event: event}, func() error {
+			return NewUndefinedEventError(src, event, s.stateNames)
+		})
+	}
+	err := s.transitionFrom(attempt{src: src, dst: dst, event: event})
+	if tErr, ok := err.(*TransitionError); ok {
+		tErr.Event = event
+		tErr.msg = fmt.Sprintf("event %s: %s", event, tErr.msg)
+	} else if err != nil {
+		return NewEventError(src, dst, event, err, s.stateNames)
+	}
+	return err
+}
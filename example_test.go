@@ -1,7 +1,13 @@
 package lfsm_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/Eyal-Shalev/lfsm"
 )
@@ -78,4 +84,435 @@ func ExampleState_Transition() {
 	// Current state: 0.
 	// Current state: 0.
 	// Current state: 1.
-}
\ No newline at end of file
+}
+
+func ExampleMachine() {
+	type door uint32
+	const (
+		opened door = iota
+		closed
+	)
+	m := lfsm.NewMachine(lfsm.TypedConstraints[door]{
+		opened: {closed},
+		closed: {opened},
+	}, lfsm.TypedInitialState(closed), lfsm.TypedStateNames[door]{opened: "opened", closed: "closed"})
+
+	err := m.TransitionFrom(opened, closed)
+	fmt.Printf("Expected error: %s.\n", err)
+
+	if err := m.Transition(opened); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current state: %s(%d).\n", m.CurrentName(), m.Current())
+
+	// Output:
+	// Expected error: transition failed (opened -> closed) current state is not opened.
+	// Current state: opened(0).
+}
+
+func ExampleOnTransition() {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1}, 1: {0}},
+		lfsm.StateNames{0: "closed", 1: "opened"},
+		lfsm.OnExit(0, func(src, dst uint32) { fmt.Printf("exit %d.\n", src) }),
+		lfsm.OnEnter(1, func(src, dst uint32) { fmt.Printf("enter %d.\n", dst) }),
+		lfsm.OnTransition(func(src, dst uint32) { fmt.Printf("transition %d -> %d.\n", src, dst) }),
+	)
+	_ = s.Transition(1)
+
+	// Output:
+	// exit 0.
+	// enter 1.
+	// transition 0 -> 1.
+}
+
+func ExampleGuard() {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1}, 1: {0}},
+		lfsm.StateNames{0: "idle", 1: "buying"},
+		lfsm.Guard(0, 1, func(src, dst uint32) error {
+			return errors.New("insufficient balance")
+		}),
+	)
+	err := s.Transition(1)
+	fmt.Printf("Expected error: %s.\n", err)
+	fmt.Printf("Current state: %s.\n", s.CurrentName())
+
+	// Output:
+	// Expected error: transition rejected (idle -> buying): insufficient balance.
+	// Current state: idle.
+}
+
+func ExampleState_WaitFor() {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}})
+	time.AfterFunc(time.Millisecond, func() { _ = s.Transition(1) })
+
+	v, err := s.WaitFor(context.Background(), 1)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current state: %d.\n", v)
+
+	// Output: Current state: 1.
+}
+
+func ExampleState_Subscribe() {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}}, lfsm.StateNames{0: "closed", 1: "opened"})
+	changes, cancel := s.Subscribe(10)
+
+	_ = s.Transition(1)
+	_ = s.Transition(0)
+	cancel()
+
+	for c := range changes {
+		fmt.Printf("#%d: %s -> %s.\n", c.Seq, c.SrcName, c.DstName)
+	}
+
+	// Output:
+	// #1: closed -> opened.
+	// #2: opened -> closed.
+}
+
+func ExampleState_Fire() {
+	const (
+		unpaid uint32 = iota
+		paid
+		canceled
+	)
+	s := lfsm.NewState(
+		lfsm.Constraints{},
+		lfsm.StateNames{unpaid: "unpaid", paid: "paid", canceled: "canceled"},
+		lfsm.Events{
+			unpaid: {"pay": paid, "cancel": canceled},
+			paid:   {"cancel": canceled},
+		},
+	)
+	if err := s.Fire("cancel"); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current state: %s.\n", s.CurrentName())
+
+	err := s.Fire("pay")
+	fmt.Printf("Expected error: %s.\n", err)
+
+	// Output:
+	// Current state: canceled.
+	// Expected error: event pay: undefined in state canceled.
+}
+
+func ExampleState_InState() {
+	const (
+		active uint32 = iota
+		paying
+		paid
+		canceled
+	)
+	s := lfsm.NewState(
+		lfsm.Constraints{active: {canceled}, paying: {paid}},
+		lfsm.InitialState(paying),
+		lfsm.Substates(active, paying, paid),
+	)
+	fmt.Printf("In active: %t.\n", s.InState(active))
+
+	if err := s.Transition(canceled); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current state: %d, in active: %t.\n", s.Current(), s.InState(active))
+
+	// Output:
+	// In active: true.
+	// Current state: 3, in active: false.
+}
+
+func ExampleRegions() {
+	const (
+		disconnected uint32 = iota
+		connected
+	)
+	const (
+		anonymous uint32 = iota
+		authenticated
+	)
+	rs := lfsm.NewRegions(
+		lfsm.Region{
+			Name:        "connection",
+			Constraints: lfsm.Constraints{disconnected: {connected}, connected: {disconnected}},
+			StateNames:  lfsm.StateNames{disconnected: "disconnected", connected: "connected"},
+		},
+		lfsm.Region{
+			Name:        "auth",
+			Constraints: lfsm.Constraints{anonymous: {authenticated}, authenticated: {anonymous}},
+			StateNames:  lfsm.StateNames{anonymous: "anonymous", authenticated: "authenticated"},
+		},
+	)
+
+	if err := rs.Transition([]uint32{connected, authenticated}); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current states: %v.\n", rs.CurrentNames())
+
+	err := rs.TransitionFrom([]uint32{connected, anonymous}, []uint32{disconnected, anonymous})
+	fmt.Printf("Expected error: %s.\n", err)
+
+	// Output:
+	// Current states: [connected authenticated].
+	// Expected error: region auth: transition failed (anonymous -> anonymous) current state is not anonymous.
+}
+
+func ExampleState64() {
+	const (
+		idle    uint64 = 1 << 40
+		running uint64 = 1 << 41
+	)
+	s := lfsm.NewState64(
+		lfsm.Constraints64{idle: {running}, running: {idle}},
+		lfsm.InitialState64(idle),
+		lfsm.StateNames64{idle: "idle", running: "running"},
+	)
+	err := s.TransitionFrom(running, idle)
+	fmt.Printf("Expected error: %s.\n", err)
+
+	if err := s.Transition(running); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current state: %s(%d).\n", s.CurrentName(), s.Current())
+
+	// Output:
+	// Expected error: transition failed (running -> idle) current state is not running.
+	// Current state: running(2199023255552).
+}
+
+func ExampleState_TransitionFromSnapshot() {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}}, lfsm.StateNames{0: "idle", 1: "busy"})
+	snap := s.Snapshot()
+
+	// The state machine leaves idle and returns to it after the snapshot was taken.
+	_ = s.Transition(1)
+	_ = s.Transition(0)
+
+	err := s.TransitionFromSnapshot(snap, 1)
+	fmt.Printf("Expected error: %s.\n", err)
+
+	if err := s.TransitionFromSnapshot(s.Snapshot(), 1); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current state: %s.\n", s.CurrentName())
+
+	// Output:
+	// Expected error: transition failed (idle -> busy) state changed since generation 0 (current state is idle at generation 2).
+	// Current state: busy.
+}
+
+func ExampleAnalyze() {
+	a := lfsm.Analyze(lfsm.Constraints{
+		0: {1},
+		1: {0, 2},
+		2: {3},
+		4: {0},
+	}, 0)
+	fmt.Printf("Reachable: %v.\n", a.Reachable)
+	fmt.Printf("Unreachable: %v.\n", a.Unreachable)
+	fmt.Printf("Terminal: %v.\n", a.Terminal)
+	fmt.Printf("Undeclared: %v.\n", a.Undeclared)
+	fmt.Printf("Components: %v.\n", a.Components)
+
+	// Output:
+	// Reachable: [0 1 2 3].
+	// Unreachable: [4].
+	// Terminal: [3].
+	// Undeclared: [3].
+	// Components: [[3] [2] [0 1] [4]].
+}
+
+func ExampleNewStateE() {
+	_, err := lfsm.NewStateE(
+		lfsm.Constraints{0: {1, 1}, 1: {0}},
+		lfsm.InitialState(2),
+		lfsm.StateNames{0: "opened", 1: "closed", 3: "closed"},
+	)
+	fmt.Println(err)
+
+	// Output: invalid configuration: unknown initial state 2; duplicate transition (opened -> closed); name of unknown state 3 (closed); duplicate state name closed (1 and 3)
+}
+
+func ExampleFinalStates() {
+	const (
+		shipped uint32 = iota
+		delivered
+	)
+	s := lfsm.NewState(
+		lfsm.Constraints{shipped: {delivered}, delivered: {shipped}},
+		lfsm.StateNames{shipped: "shipped", delivered: "delivered"},
+		lfsm.FinalStates(delivered),
+	)
+	if err := s.Transition(delivered); err != nil {
+		panic(err)
+	}
+	<-s.Done()
+	fmt.Printf("Current state: %s, final: %t.\n", s.CurrentName(), s.IsFinal())
+
+	err := s.Transition(shipped)
+	fmt.Printf("Expected error: %s.\n", err)
+
+	// Output:
+	// Current state: delivered, final: true.
+	// Expected error: transition failed (delivered -> shipped) delivered is a final state.
+}
+
+func ExampleState_MarshalJSON() {
+	newOrder := func() *lfsm.State {
+		return lfsm.NewState(
+			lfsm.Constraints{0: {1}, 1: {2}},
+			lfsm.StateNames{0: "creating", 1: "paying", 2: "paid"},
+		)
+	}
+	saved := newOrder()
+	_ = saved.Transition(1)
+	data, err := json.Marshal(saved)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Saved: %s.\n", data)
+
+	restored := newOrder()
+	if err := json.Unmarshal(data, restored); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Restored state: %s.\n", restored.CurrentName())
+
+	err = json.Unmarshal([]byte(`"shipped"`), restored)
+	fmt.Printf("Expected error: %s.\n", err)
+
+	// Output:
+	// Saved: "paying".
+	// Restored state: paying.
+	// Expected error: lfsm: unknown state "shipped".
+}
+
+func ExampleLoadDefinition() {
+	s, err := lfsm.LoadDefinition(strings.NewReader(`{
+		"states": [
+			{"id": 0, "name": "creating"},
+			{"id": 1, "name": "paying"},
+			{"id": 2, "name": "paid"},
+			{"id": 3, "name": "canceled"}
+		],
+		"initial": "creating",
+		"transitions": {
+			"creating": ["paying"],
+			"paying": ["paid", "creating"]
+		},
+		"events": {
+			"creating": {"cancel": "canceled"}
+		},
+		"final": ["paid", "canceled"]
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	if err := s.Fire("cancel"); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Current state: %s, final: %t.\n", s.CurrentName(), s.IsFinal())
+
+	// Output: Current state: canceled, final: true.
+}
+
+func ExampleState_WriteMermaid() {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1}},
+		lfsm.StateNames{0: "paying", 1: "paid", 2: "canceled"},
+		lfsm.Events{0: {"cancel": 2}},
+		lfsm.FinalStates(1, 2),
+	)
+	if err := s.WriteMermaid(os.Stdout); err != nil {
+		panic(err)
+	}
+
+	// Output:
+	// stateDiagram-v2
+	//     state "paying" as n0
+	//     state "paid" as n1
+	//     state "canceled" as n2
+	//     [*] --> n0
+	//     n0 --> n1
+	//     n0 --> n2 : cancel
+	//     n1 --> [*]
+	//     n2 --> [*]
+	//     classDef current fill:#ddd,font-weight:bold
+	//     class n0 current
+}
+
+func ExampleState_WritePlantUML() {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1}, 1: {0}},
+		lfsm.StateNames{0: "opened", 1: "closed"},
+		lfsm.InitialState(1),
+	)
+	if err := s.WritePlantUML(os.Stdout); err != nil {
+		panic(err)
+	}
+
+	// Output:
+	// @startuml
+	// state "opened" as n0
+	// state "closed" as n1 #lightgrey
+	// [*] --> n1
+	// n0 --> n1
+	// n1 --> n0
+	// @enduml
+}
+
+func ExampleState_WriteSCXML() {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1}},
+		lfsm.StateNames{0: "paying", 1: "paid"},
+		lfsm.Events{0: {"pay": 1}},
+		lfsm.FinalStates(1),
+	)
+	if err := s.WriteSCXML(os.Stdout); err != nil {
+		panic(err)
+	}
+
+	// Output:
+	// <?xml version="1.0" encoding="UTF-8"?>
+	// <scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0" initial="paying">
+	//   <!-- current: paying -->
+	//   <state id="paying">
+	//     <transition event="pay" target="paid"/>
+	//   </state>
+	//   <final id="paid"/>
+	// </scxml>
+}
+
+func ExampleState_WriteDOT() {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1}, 1: {0}},
+		lfsm.StateNames{0: "opened", 1: "closed"},
+		lfsm.Events{0: {"close": 1}},
+	)
+	if err := s.WriteDOT(os.Stdout, lfsm.DOTOptions{RankDir: "LR", EdgeLabels: true}); err != nil {
+		panic(err)
+	}
+
+	// Output: digraph g{rankdir="LR";s[label="",shape=none,height=.0,width=.0];s->n0;n0[label="opened",style=filled];n1[label="closed"];n0->n1[label="close"];n1->n0;}
+}
+
+func ExampleHistory() {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1}, 1: {2}},
+		lfsm.StateNames{0: "paying", 1: "paid", 2: "canceled"},
+		lfsm.Events{1: {"cancel": 2}},
+		lfsm.History(10),
+	)
+	_ = s.TransitionTagged(1, "checkout")
+	_ = s.Fire("cancel")
+
+	for _, e := range s.History() {
+		fmt.Printf("#%d: %d -> %d (event: %q, tag: %q).\n", e.Generation, e.Src, e.Dst, e.Event, e.Tag)
+	}
+
+	// Output:
+	// #1: 0 -> 1 (event: "", tag: "checkout").
+	// #2: 1 -> 2 (event: "cancel", tag: "").
+}
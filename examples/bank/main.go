@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/big"
@@ -65,15 +66,12 @@ func (ba *BankAccount) Withdraw(amount *big.Int) (func(), error) {
 	l.Printf("[%s] Withdrawn %v (new balance %v)", ba.name, amount, ba.balance)
 
 	return func() {
-		for {
-			if err := ba.state.Transition(accountDeposit); err == nil {
-				ba.balance.Add(ba.balance, amount)
-				l.Printf("[%s] Returned %v (new balance %v)", ba.name, amount, ba.balance)
-				_ = ba.state.Transition(accountIdle)
-				return
-			}
-			time.Sleep(time.Nanosecond)
+		if err := ba.state.TransitionWait(context.Background(), accountDeposit); err != nil {
+			l.Fatalln(err)
 		}
+		ba.balance.Add(ba.balance, amount)
+		l.Printf("[%s] Returned %v (new balance %v)", ba.name, amount, ba.balance)
+		_ = ba.state.Transition(accountIdle)
 	}, nil
 }
 
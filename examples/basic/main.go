@@ -24,7 +24,7 @@ func main() {
 		lfsm.StateNames{opened: "opened",closed: "closed"},
 	)
 
-	l.Println(s) // digraph g{s[label="",shape=none,height=.0,width=.0];s->n1;n0[label="opened"];n1[label="closed"];n0->n1;n1->n0;}
+	l.Println(s) // digraph g{s[label="",shape=none,height=.0,width=.0];s->n1;n0[label="opened"];n1[label="closed",style=filled];n0->n1;n1->n0;}
 
 	l.Printf("Current state: %s", s.CurrentName()) // Current state: closed
 
@@ -3,9 +3,9 @@
 package main
 
 import (
+	"context"
 	"log"
 	"sync"
-	"time"
 
 	"github.com/Eyal-Shalev/lfsm"
 )
@@ -29,11 +29,8 @@ func NewMutex() *Mutex {
 }
 
 func (m Mutex) Lock() {
-	for {
-		if m.state.Transition(locked) == nil {
-			return
-		}
-		time.Sleep(time.Nanosecond)
+	if err := m.state.TransitionWait(context.Background(), locked); err != nil {
+		panic(err)
 	}
 }
 
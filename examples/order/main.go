@@ -63,7 +63,7 @@ func (o *order) checkout() error {
 }
 
 func (o *order) pay() error {
-	err := o.state.Transition(paying)
+	err := o.state.Fire("pay")
 	if err != nil {
 		return err
 	}
@@ -71,54 +71,64 @@ func (o *order) pay() error {
 		log.Println("payment failed")
 		return o.state.Transition(finalizing)
 	}
-	time.AfterFunc(time.Second, func() {
-		logErr(o.state.Transition(processing))
-	})
-	time.AfterFunc(time.Second*2, func() {
-		if rand.Intn(2) == 1 {
-			log.Println("processing failed")
-			logErr(o.state.Transition(canceled))
-			return
-		}
-		logErr(o.state.Transition(shipped))
-	})
 	return o.state.Transition(paid)
 }
 
 func (o *order) cancel() error {
-	return o.state.Transition(canceled)
+	return o.state.Fire("cancel")
 }
 
-func newOrder() *order {
-	return &order{
-		state: lfsm.NewState(
-			lfsm.Constraints{
-				creating:   {adding, finalizing, canceled},
-				adding:     {creating, canceled},
-				finalizing: {adding, paying, canceled},
-				paying:     {paid, finalizing},
-				paid:       {processing, canceled},
-				processing: {shipped, canceled},
-				shipped:    {delivered},
-				canceled:   {canceled},
-			},
-			lfsm.InitialState(creating),
-			lfsm.StateNames{
-				creating:   "creating",
-				adding:     "adding",
-				finalizing: "finalizing",
-				paying:     "paying",
-				paid:       "paid",
-				processing: "processing",
-				shipped:    "shipped",
-				delivered:  "delivered",
-				canceled:   "canceled",
-			},
-		),
-		items: map[string]int{},
+// process is called when the order enters processing, if processing doesn't fail the order is shipped on timeout.
+func (o *order) process(_, _ uint32) {
+	if rand.Intn(2) == 1 {
+		log.Println("processing failed")
+		logErr(o.cancel())
 	}
 }
 
+func newOrder() *order {
+	o := &order{items: map[string]int{}}
+	o.state = lfsm.NewState(
+		lfsm.Constraints{
+			creating:   {adding, finalizing, canceled},
+			adding:     {creating, canceled},
+			finalizing: {adding, paying, canceled},
+			paying:     {paid, finalizing},
+			paid:       {processing, canceled},
+			processing: {shipped, canceled},
+			shipped:    {delivered},
+		},
+		lfsm.InitialState(creating),
+		lfsm.FinalStates(delivered, canceled),
+		lfsm.StateNames{
+			creating:   "creating",
+			adding:     "adding",
+			finalizing: "finalizing",
+			paying:     "paying",
+			paid:       "paid",
+			processing: "processing",
+			shipped:    "shipped",
+			delivered:  "delivered",
+			canceled:   "canceled",
+		},
+		lfsm.Events{
+			creating:   {"cancel": canceled},
+			adding:     {"cancel": canceled},
+			finalizing: {"pay": paying, "cancel": canceled},
+			paid:       {"cancel": canceled},
+			processing: {"cancel": canceled},
+		},
+		lfsm.Timeout(paying, 30*time.Second, finalizing),
+		lfsm.Timeout(paid, time.Second, processing),
+		lfsm.Timeout(processing, time.Second, shipped),
+		lfsm.OnEnter(processing, o.process),
+		lfsm.OnTransition(func(src, dst uint32) {
+			log.Printf("order moved from %d to %d", src, dst)
+		}),
+	)
+	return o
+}
+
 func fatalIfErr(err error) {
 	if err != nil {
 		log.Fatal(err)
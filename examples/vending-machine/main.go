@@ -22,12 +22,9 @@ type VendingMachine struct {
 	state    *lfsm.State
 	balance  uint32
 	products map[string]uint32
-}
 
-func panicIfErr(err error) {
-	if err != nil {
-		panic(err)
-	}
+	// requested is the product that the customer is trying to buy, it is checked by the guard of idle -> buying.
+	requested atomic.Value
 }
 
 func (vm *VendingMachine) addCash(amount uint32) error {
@@ -52,18 +49,13 @@ func (vm *VendingMachine) withdraw() error {
 }
 
 func (vm *VendingMachine) buy(product string) error {
+	vm.requested.Store(product)
 	err := vm.state.Transition(buying)
 	if err != nil {
-		panicIfErr(vm.state.Transition(idle))
 		return fmt.Errorf("cannot buy because %w", err)
 	}
-	balance := atomic.LoadUint32(&vm.balance)
-	if balance < vm.products[product] {
-		panicIfErr(vm.state.Transition(idle))
-		return fmt.Errorf("cannot buy %s - missing %v$", product, float64(vm.products[product]-balance)/100)
-	}
 	atomic.AddUint32(&vm.balance, -vm.products[product])
-	balance = atomic.LoadUint32(&vm.balance)
+	balance := atomic.LoadUint32(&vm.balance)
 	vm.dropItem()
 	log.Printf("%s was purchased, %v$ is left in the machine", product, float64(balance)/100)
 	return vm.state.Transition(idle)
@@ -87,27 +79,38 @@ func fatalIfErr(err error) {
 
 func main() {
 	vm := VendingMachine{
-		state: lfsm.NewState(
-			lfsm.Constraints{
-				idle:        {addingCash, buying, withdrawing},
-				addingCash:  {idle},
-				buying:      {idle},
-				withdrawing: {idle},
-			},
-			lfsm.InitialState(idle),
-			lfsm.StateNames{
-				idle:        "Idle",
-				addingCash:  "Adding cash",
-				buying:      "Buying",
-				withdrawing: "Withdrawing",
-			},
-		),
 		products: map[string]uint32{
 			"coke cola":    200,
 			"pepsi cola":   150,
 			"orange juice": 250,
 		},
 	}
+	vm.state = lfsm.NewState(
+		lfsm.Constraints{
+			idle:        {addingCash, buying, withdrawing},
+			addingCash:  {idle},
+			buying:      {idle},
+			withdrawing: {idle},
+		},
+		lfsm.InitialState(idle),
+		lfsm.StateNames{
+			idle:        "Idle",
+			addingCash:  "Adding cash",
+			buying:      "Buying",
+			withdrawing: "Withdrawing",
+		},
+		lfsm.Guard(idle, buying, func(src, dst uint32) error {
+			product, _ := vm.requested.Load().(string)
+			price, ok := vm.products[product]
+			if !ok {
+				return fmt.Errorf("unknown product %q", product)
+			}
+			if balance := atomic.LoadUint32(&vm.balance); balance < price {
+				return fmt.Errorf("%s is missing %v$", product, float64(price-balance)/100)
+			}
+			return nil
+		}),
+	)
 
 	logIfErr(vm.buy("coke cola"))
 
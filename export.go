@@ -0,0 +1,180 @@
+package lfsm
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// WriteMermaid writes the Mermaid state diagram of this state machine to w.
+// The current state is highlighted with the "current" class, and final states transition to the end state.
+//
+// See: https://mermaid.js.org/syntax/stateDiagram.html
+func (s *State) WriteMermaid(w io.Writer) error {
+	g := s.graph()
+	ew := &exportWriter{w: w}
+	ew.printf("stateDiagram-v2\n")
+	var declare func(v uint32, indent string)
+	declare = func(v uint32, indent string) {
+		n := g.node(v)
+		ew.printf("%sstate \"%s\" as n%d\n", indent, strings.ReplaceAll(n.name, `"`, "#quot;"), v)
+		if children, ok := g.children[v]; ok {
+			ew.printf("%sstate n%d {\n", indent, v)
+			for _, child := range children {
+				declare(child, indent+"    ")
+			}
+			ew.printf("%s}\n", indent)
+		}
+	}
+	for _, v := range g.roots() {
+		declare(v, "    ")
+	}
+	ew.printf("    [*] --> n%d\n", g.initial)
+	for _, e := range g.edges {
+		ew.printf("    n%d --> n%d", e.src, e.dst)
+		if len(e.events) > 0 {
+			ew.printf(" : %s", strings.Join(e.events, ", "))
+		}
+		ew.printf("\n")
+	}
+	for _, n := range g.nodes {
+		if n.final {
+			ew.printf("    n%d --> [*]\n", n.id)
+		}
+	}
+	for _, n := range g.nodes {
+		if n.current {
+			ew.printf("    classDef current fill:#ddd,font-weight:bold\n")
+			ew.printf("    class n%d current\n", n.id)
+		}
+	}
+	return ew.err
+}
+
+// WritePlantUML writes the PlantUML state diagram of this state machine to w.
+// The current state is highlighted with a grey background, and final states transition to the end state.
+//
+// See: https://plantuml.com/state-diagram
+func (s *State) WritePlantUML(w io.Writer) error {
+	g := s.graph()
+	ew := &exportWriter{w: w}
+	ew.printf("@startuml\n")
+	var declare func(v uint32, indent string)
+	declare = func(v uint32, indent string) {
+		n := g.node(v)
+		ew.printf(`%sstate "%s" as n%d`, indent, strings.ReplaceAll(n.name, `"`, "'"), v)
+		if n.current {
+			ew.printf(" #lightgrey")
+		}
+		if children, ok := g.children[v]; ok {
+			ew.printf(" {\n")
+			for _, child := range children {
+				declare(child, indent+"  ")
+			}
+			ew.printf("%s}", indent)
+		}
+		ew.printf("\n")
+	}
+	for _, v := range g.roots() {
+		declare(v, "")
+	}
+	ew.printf("[*] --> n%d\n", g.initial)
+	for _, e := range g.edges {
+		ew.printf("n%d --> n%d", e.src, e.dst)
+		if len(e.events) > 0 {
+			ew.printf(" : %s", strings.Join(e.events, ", "))
+		}
+		ew.printf("\n")
+	}
+	for _, n := range g.nodes {
+		if n.final {
+			ew.printf("n%d --> [*]\n", n.id)
+		}
+	}
+	ew.printf("@enduml\n")
+	return ew.err
+}
+
+// WriteSCXML writes the SCXML document of this state machine to w.
+//
+// States are identified by their names when they are valid and unique XML identifiers. Since eventless SCXML
+// transitions are taken automatically, transitions without events use the destination identifier as their event.
+// Substates are exported as flat states, and the current state is recorded in a comment.
+//
+// See: https://www.w3.org/TR/scxml/
+func (s *State) WriteSCXML(w io.Writer) error {
+	g := s.graph()
+	ew := &exportWriter{w: w}
+
+	ids := make(map[uint32]string, len(g.nodes))
+	used := make(map[string]bool, len(g.nodes))
+	for _, n := range g.nodes {
+		if n.named && isXMLName(n.name) && !used[n.name] {
+			ids[n.id] = n.name
+			used[n.name] = true
+		}
+	}
+	for _, n := range g.nodes {
+		if _, ok := ids[n.id]; !ok {
+			id := "n" + strconv.FormatUint(uint64(n.id), 10)
+			for used[id] {
+				id = "_" + id
+			}
+			ids[n.id] = id
+			used[id] = true
+		}
+	}
+	escape := func(text string) string {
+		b := &strings.Builder{}
+		_ = xml.EscapeText(b, []byte(text))
+		return b.String()
+	}
+
+	ew.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	ew.printf("<scxml xmlns=\"http://www.w3.org/2005/07/scxml\" version=\"1.0\" initial=\"%s\">\n", ids[g.initial])
+	for _, n := range g.nodes {
+		if n.current {
+			ew.printf("  <!-- current: %s -->\n", strings.ReplaceAll(escape(ids[n.id]), "--", "- -"))
+		}
+	}
+	for _, n := range g.nodes {
+		if n.final {
+			ew.printf("  <final id=\"%s\"/>\n", escape(ids[n.id]))
+			continue
+		}
+		var edges []graphEdge
+		for _, e := range g.edges {
+			if e.src == n.id {
+				edges = append(edges, e)
+			}
+		}
+		if len(edges) == 0 {
+			ew.printf("  <state id=\"%s\"/>\n", escape(ids[n.id]))
+			continue
+		}
+		ew.printf("  <state id=\"%s\">\n", escape(ids[n.id]))
+		for _, e := range edges {
+			event := strings.Join(e.events, " ")
+			if event == "" {
+				event = ids[e.dst]
+			}
+			ew.printf("    <transition event=\"%s\" target=\"%s\"/>\n", escape(event), escape(ids[e.dst]))
+		}
+		ew.printf("  </state>\n")
+	}
+	ew.printf("</scxml>\n")
+	return ew.err
+}
+
+// isXMLName reports whether name is a valid (colon-less) XML name.
+func isXMLName(name string) bool {
+	for i, r := range name {
+		if unicode.IsLetter(r) || r == '_' || (i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.')) {
+			continue
+		}
+		return false
+	}
+	return name != ""
+}
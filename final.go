@@ -0,0 +1,33 @@
+package lfsm
+
+// FinalStates marks states as final (accepting) states.
+//
+// Once the state machine reaches a final state it stays there, any transition out of it fails with a
+// *FinalStateError, and the channel returned by Done is closed.
+func FinalStates(v ...uint32) option {
+	return optionFn(func(s *State) {
+		if s.final == nil {
+			s.final = make(map[uint32]bool, len(v))
+		}
+		for _, f := range v {
+			s.final[f] = true
+		}
+	})
+}
+
+// IsFinal reports whether the current state is a final state.
+func (s *State) IsFinal() bool {
+	return s.final[s.Current()]
+}
+
+// Done returns a channel that is closed the first time the state machine reaches a final state.
+func (s *State) Done() <-chan struct{} {
+	return s.done
+}
+
+// reached closes the Done channel if v is a final state.
+func (s *State) reached(v uint32) {
+	if s.final[v] {
+		s.doneOnce.Do(func() { close(s.done) })
+	}
+}
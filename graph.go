@@ -0,0 +1,114 @@
+package lfsm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// graph is the model of a state machine that all the exporters render.
+type graph struct {
+	initial  uint32
+	nodes    []graphNode
+	edges    []graphEdge
+	children map[uint32][]uint32
+}
+
+type graphNode struct {
+	id        uint32
+	name      string
+	named     bool
+	current   bool
+	final     bool
+	parent    uint32
+	hasParent bool
+}
+
+type graphEdge struct {
+	src, dst uint32
+	// events holds the (sorted) names of the events that trigger this transition.
+	events []string
+}
+
+// graph builds the model of this state machine, with nodes sorted by state and edges by source and destination.
+func (s *State) graph() *graph {
+	current := s.Current()
+	states := s.known()
+	states[s.initial] = true
+	states[current] = true
+	for v := range s.stateNames {
+		states[v] = true
+	}
+	for v := range s.final {
+		states[v] = true
+	}
+	for child, parent := range s.parents {
+		states[child] = true
+		states[parent] = true
+	}
+
+	g := &graph{initial: s.initial, children: make(map[uint32][]uint32)}
+	for _, v := range sortedKeys(states) {
+		_, named := s.stateNames[v]
+		parent, hasParent := s.parents[v]
+		if chain := s.ancestry(v); hasParent {
+			// Substates of a cyclic declaration are rendered as top level states.
+			_, cyclic := s.parents[chain[len(chain)-1]]
+			hasParent = !cyclic
+		}
+		g.nodes = append(g.nodes, graphNode{
+			id:        v,
+			name:      s.stateNames.find(v),
+			named:     named,
+			current:   v == current,
+			final:     s.final[v],
+			parent:    parent,
+			hasParent: hasParent,
+		})
+		if hasParent {
+			g.children[parent] = append(g.children[parent], v)
+		}
+	}
+
+	for _, src := range sortedKeys(s.transitions) {
+		for _, dst := range sortedKeys(s.transitions[src]) {
+			var events []string
+			for event, eDst := range s.events[src] {
+				if eDst == dst {
+					events = append(events, event)
+				}
+			}
+			sort.Strings(events)
+			g.edges = append(g.edges, graphEdge{src, dst, events})
+		}
+	}
+	return g
+}
+
+func (g *graph) node(v uint32) *graphNode {
+	i := sort.Search(len(g.nodes), func(i int) bool { return g.nodes[i].id >= v })
+	return &g.nodes[i]
+}
+
+// roots returns the nodes without a parent.
+func (g *graph) roots() []uint32 {
+	var roots []uint32
+	for _, n := range g.nodes {
+		if !n.hasParent {
+			roots = append(roots, n.id)
+		}
+	}
+	return roots
+}
+
+// exportWriter writes formatted output, and remembers the first error so exporters can check it once.
+type exportWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *exportWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
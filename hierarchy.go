@@ -0,0 +1,75 @@
+package lfsm
+
+import "sort"
+
+// Substates declares children as substates of the parent composite state.
+//
+// A substate inherits the outgoing transitions (and events) of its ancestors, so a transition from a substate is valid
+// if it is defined for the substate or for any of its ancestors. Guards of the substate and of the ancestors up to
+// the one that defines the transition are evaluated. Current always reports the leaf state, use InState to check
+// ancestry.
+func Substates(parent uint32, children ...uint32) option {
+	return optionFn(func(s *State) {
+		if s.parents == nil {
+			s.parents = make(map[uint32]uint32)
+		}
+		for _, child := range children {
+			s.parents[child] = parent
+		}
+	})
+}
+
+// InState reports whether the current state is v, or a substate (at any depth) of v.
+func (s *State) InState(v uint32) bool {
+	for _, a := range s.ancestry(s.Current()) {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestry returns v followed by its ancestors, from the closest to the farthest.
+// Cyclic declarations are cut at the first repeated state.
+func (s *State) ancestry(v uint32) []uint32 {
+	chain := []uint32{v}
+	for p, ok := s.parents[v]; ok; p, ok = s.parents[p] {
+		for _, a := range chain {
+			if a == p {
+				return chain
+			}
+		}
+		chain = append(chain, p)
+	}
+	return chain
+}
+
+// allowed returns the states whose guards apply to a transition from src to dst, starting with src and ending with
+// the ancestor that defines the transition, or false if neither src nor its ancestors define it.
+func (s *State) allowed(src, dst uint32) ([]uint32, bool) {
+	if s.transitions[src][dst] {
+		return nil, true
+	}
+	if s.parents == nil {
+		return nil, false
+	}
+	chain := s.ancestry(src)
+	for i, a := range chain {
+		if s.transitions[a][dst] {
+			return chain[:i+1], true
+		}
+	}
+	return nil, false
+}
+
+// children returns the direct substates of every composite state, sorted.
+func (s *State) children() map[uint32][]uint32 {
+	m := make(map[uint32][]uint32)
+	for child, parent := range s.parents {
+		m[parent] = append(m[parent], child)
+	}
+	for _, c := range m {
+		sort.Slice(c, func(i, j int) bool { return c[i] < c[j] })
+	}
+	return m
+}
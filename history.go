@@ -0,0 +1,98 @@
+package lfsm
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HistoryEntry describes a single successful transition, as recorded by the History option.
+// It is also the record type of the transition Journal.
+type HistoryEntry struct {
+	Src uint32 `json:"src"`
+	Dst uint32 `json:"dst"`
+	// Event is the name of the event that triggered the transition, see Fire.
+	Event string `json:"event,omitempty"`
+	// Tag is the caller tag of the transition, see TransitionTagged.
+	Tag string `json:"tag,omitempty"`
+	// Generation is the generation that the transition started, see Snapshot.
+	Generation uint32    `json:"gen"`
+	Time       time.Time `json:"time"`
+	// Restored reports that the entry is not a transition but a call to Restore, it only appears in the Journal.
+	Restored bool `json:"restored,omitempty"`
+}
+
+// History records the last n successful transitions, see State.History.
+//
+// Recording is lock-free: every transition owns the slot of its generation in the ring buffer, and publishes an
+// immutable entry to it with a compare-and-swap, so a late transition never overwrites a newer one.
+func History(n int) option {
+	return optionFn(func(s *State) {
+		if n > 0 {
+			s.history = &history{slots: make([]atomic.Pointer[HistoryEntry], n)}
+		}
+	})
+}
+
+type history struct {
+	slots []atomic.Pointer[HistoryEntry]
+}
+
+func (h *history) add(e HistoryEntry) {
+	slot := &h.slots[e.Generation%uint32(len(h.slots))]
+	for {
+		old := slot.Load()
+		if old != nil && int32(old.Generation-e.Generation) >= 0 {
+			return
+		}
+		if slot.CompareAndSwap(old, &e) {
+			return
+		}
+	}
+}
+
+// entries returns the recorded entries of the last len(slots) generations, up to generation last.
+func (h *history) entries(last uint32) []HistoryEntry {
+	n := uint32(len(h.slots))
+	entries := make([]HistoryEntry, 0, n)
+	for i := n; i > 0; i-- {
+		gen := last - (i - 1)
+		if e := h.slots[gen%n].Load(); e != nil && e.Generation == gen {
+			entries = append(entries, *e)
+		}
+	}
+	return entries
+}
+
+// History returns a copy of the transitions of the last n generations (see the History option), from the oldest to
+// the newest. Returns nil if the History option was not used.
+//
+// The window is taken by generation, so it is consistent even if concurrent transitions were recorded out of order.
+// A generation is missing from the copy if its transition is still being committed when History is called, or
+// if it was started by Restore or Replay, which are not transitions.
+func (s *State) History() []HistoryEntry {
+	if s.history == nil {
+		return nil
+	}
+	return s.history.entries(uint32(s.current.Load() >> 32))
+}
+
+// record adds the transition to the history and to the journal, if they are enabled.
+func (s *State) record(a attempt, word uint64) {
+	if s.history == nil && s.journal == nil {
+		return
+	}
+	e := HistoryEntry{
+		Src:        a.src,
+		Dst:        a.dst,
+		Event:      a.event,
+		Tag:        a.tag,
+		Generation: uint32(word >> 32),
+		Time:       s.clock.Now(),
+	}
+	if s.history != nil {
+		s.history.add(e)
+	}
+	if s.journal != nil {
+		s.journal.add(e)
+	}
+}
@@ -0,0 +1,185 @@
+package lfsm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// JournalSink receives every successful transition of a state machine, see Journal.
+//
+// Entries are appended one at a time, in the order of their generations, so sinks don't need to be safe for
+// concurrent use.
+type JournalSink interface {
+	Append(e HistoryEntry)
+}
+
+// Journal appends every successful transition to sink, so the state machine can later be rebuilt with Replay.
+//
+// Transitions of concurrent goroutines may complete out of order, so entries are buffered until all the preceding
+// generations were appended. This buffering is guarded by a mutex that only exists when a Journal is configured, and
+// the sink is called outside of it, by one goroutine at a time.
+//
+// If a generation is never recorded (e.g. because a Metrics implementation panicked while it was committed), at most
+// 1024 entries are buffered after it, and then the missing generation is skipped.
+func Journal(sink JournalSink) option {
+	return optionFn(func(s *State) {
+		s.journal = &journal{sink: sink, pending: make(map[uint32]HistoryEntry)}
+	})
+}
+
+// maxPendingJournal is the number of entries that a Journal buffers before it skips a missing generation.
+const maxPendingJournal = 1024
+
+type journal struct {
+	mu       sync.Mutex
+	sink     JournalSink
+	last     uint32
+	pending  map[uint32]HistoryEntry
+	flushing bool
+}
+
+func (j *journal) add(e HistoryEntry) {
+	j.mu.Lock()
+	if int32(e.Generation-j.last) <= 0 {
+		j.mu.Unlock()
+		return // The journal was reset since.
+	}
+	j.pending[e.Generation] = e
+	if j.flushing {
+		// The flushing goroutine appends the entry once it is ready.
+		j.mu.Unlock()
+		return
+	}
+	j.flushing = true
+	for {
+		ready := j.ready()
+		if len(ready) == 0 {
+			j.flushing = false
+			j.mu.Unlock()
+			return
+		}
+		j.mu.Unlock()
+		for _, e := range ready {
+			j.sink.Append(e)
+		}
+		j.mu.Lock()
+	}
+}
+
+// ready removes the entries that follow the last appended generation from pending, and returns them in order.
+func (j *journal) ready() []HistoryEntry {
+	if len(j.pending) > maxPendingJournal {
+		// Skip to the oldest buffered generation (generations wrap around, so they are compared relative to last).
+		oldest := j.last
+		for gen := range j.pending {
+			if oldest == j.last || gen-j.last < oldest-j.last {
+				oldest = gen
+			}
+		}
+		j.last = oldest - 1
+	}
+	var ready []HistoryEntry
+	for {
+		next, ok := j.pending[j.last+1]
+		if !ok {
+			return ready
+		}
+		delete(j.pending, next.Generation)
+		j.last = next.Generation
+		ready = append(ready, next)
+	}
+}
+
+// reset sets the generation that the next appended entry must follow.
+func (j *journal) reset(generation uint32) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.last = generation
+	for gen := range j.pending {
+		if int32(gen-generation) <= 0 {
+			delete(j.pending, gen)
+		}
+	}
+}
+
+// JournalWriter is a JournalSink that writes every entry to an io.Writer as a line of JSON.
+// Use an append-only file (os.O_APPEND) for durability.
+type JournalWriter struct {
+	enc *json.Encoder
+	err error
+}
+
+// NewJournalWriter creates a JournalWriter that writes to w.
+func NewJournalWriter(w io.Writer) *JournalWriter {
+	return &JournalWriter{enc: json.NewEncoder(w)}
+}
+
+// Append implements JournalSink. After the first write error all the following entries are dropped.
+func (jw *JournalWriter) Append(e HistoryEntry) {
+	if jw.err == nil {
+		jw.err = jw.enc.Encode(e)
+	}
+}
+
+// Err returns the first write error, if any.
+func (jw *JournalWriter) Err() error {
+	return jw.err
+}
+
+// Replay rebuilds the state machine from a journal that was written by a JournalWriter.
+//
+// Every entry is validated against the transitions of the state machine, starting from the current state, and must
+// start from the destination of the previous entry. Restored entries (see Restore) may move to any known state.
+// Like Restore, replaying doesn't call callbacks, guards, subscribers or sinks, but it does adopt the recorded
+// generations, so journaling continues where it stopped.
+// Replay must be called before the state machine is used by other goroutines.
+func (s *State) Replay(r io.Reader) error {
+	word := s.current.Load()
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for i := 1; ; i++ {
+		var e HistoryEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("lfsm: journal entry %d: %w", i, err)
+		}
+
+		if uint32(word) != e.Src {
+			return fmt.Errorf("lfsm: journal entry %d: %w", i, NewStaleSourceError(e.Src, e.Dst, uint32(word), s.stateNames))
+		}
+		if err := s.replayable(e); err != nil {
+			return fmt.Errorf("lfsm: journal entry %d: %w", i, err)
+		}
+		word = uint64(e.Generation)<<32 | uint64(e.Dst)
+	}
+
+	s.current.Store(word)
+	if s.journal != nil {
+		s.journal.reset(uint32(word >> 32))
+	}
+	s.instrument()
+	s.arm(word)
+	s.reached(uint32(word))
+	s.broadcast()
+	return nil
+}
+
+// replayable validates that the journal entry e can be replayed from its source state.
+func (s *State) replayable(e HistoryEntry) error {
+	if e.Restored {
+		if !s.known()[e.Dst] {
+			return fmt.Errorf("%w %s", ErrUnknownState, s.stateNames.find(e.Dst))
+		}
+		return nil
+	}
+	if s.final[e.Src] {
+		return NewFinalStateError(e.Src, e.Dst, s.stateNames)
+	}
+	if _, ok := s.allowed(e.Src, e.Dst); !ok {
+		return NewInvalidTransitionError(e.Src, e.Dst, s.stateNames)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package lfsm
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger emits a structured record to l for every transition attempt.
+//
+// Records have the "transition" message, and the src, dst, src_name, dst_name and outcome attributes. Failed attempts
+// also have the error_kind (the outcome) and error attributes, and event or tag attributes are added when set.
+// The records are logged at slog.LevelInfo for successful transitions, and slog.LevelWarn for failed ones, see
+// LogLevels.
+func Logger(l *slog.Logger) option {
+	return optionFn(func(s *State) {
+		s.logger = l
+	})
+}
+
+// LogLevels sets the levels of the records that Logger emits, for successful and for failed transition attempts.
+func LogLevels(success, failure slog.Level) option {
+	return optionFn(func(s *State) {
+		s.logLevels = [2]slog.Level{success, failure}
+	})
+}
+
+// log emits the record of a transition attempt, if a Logger was configured.
+func (s *State) log(a attempt, err error) {
+	if s.logger == nil {
+		return
+	}
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	level := s.logLevels[0]
+	if err != nil {
+		level = s.logLevels[1]
+	}
+	if !s.logger.Enabled(ctx, level) {
+		return
+	}
+
+	outcome := outcomeOf(err)
+	attrs := make([]slog.Attr, 0, 9)
+	attrs = append(attrs,
+		slog.Uint64("src", uint64(a.src)),
+		slog.Uint64("dst", uint64(a.dst)),
+		slog.String("src_name", s.stateNames.find(a.src)),
+		slog.String("dst_name", s.stateNames.find(a.dst)),
+		slog.String("outcome", outcome.String()),
+	)
+	if a.event != "" {
+		attrs = append(attrs, slog.String("event", a.event))
+	}
+	if a.tag != "" {
+		attrs = append(attrs, slog.String("tag", a.tag))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error_kind", outcome.String()), slog.String("error", err.Error()))
+	}
+	s.logger.LogAttrs(ctx, level, "transition", attrs...)
+}
+
+// LogValue implements slog.LogValuer, it logs the current state, its name and its generation.
+func (s *State) LogValue() slog.Value {
+	snap := s.Snapshot()
+	return slog.GroupValue(
+		slog.Uint64("state", uint64(snap.State)),
+		slog.String("name", s.stateNames.find(snap.State)),
+		slog.Uint64("generation", uint64(snap.Generation)),
+	)
+}
+
+// LogValue implements slog.LogValuer, it logs the transition states, their names, the event and the message.
+func (f *TransitionError) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Uint64("src", uint64(f.Src)),
+		slog.Uint64("dst", uint64(f.Dst)),
+		slog.String("src_name", f.stateNames.find(f.Src)),
+		slog.String("dst_name", f.stateNames.find(f.Dst)),
+	}
+	if f.Event != "" {
+		attrs = append(attrs, slog.String("event", f.Event))
+	}
+	attrs = append(attrs, slog.String("error", f.Error()))
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,197 @@
+package lfsm
+
+import (
+	"errors"
+)
+
+// TypedConstraints is the type-safe version of Constraints, keyed by the caller's own state type.
+type TypedConstraints[S ~uint32] map[S][]S
+
+func (m TypedConstraints[S]) untyped() Constraints {
+	c := make(Constraints, len(m))
+	for src, dsts := range m {
+		c[uint32(src)] = untypedStates(dsts)
+	}
+	return c
+}
+
+// MachineOption configures a Machine of states of type S, see NewMachine.
+//
+// Options of this package that take states (like InitialState or OnEnter) are not MachineOptions, so the compiler
+// rejects them, use their Typed versions instead. Options that don't take states can be used through TypedOption.
+type MachineOption[S ~uint32] interface {
+	apply(*State)
+	typed(S)
+}
+
+// typedOptionFn is a MachineOption that is tied to the state type S.
+type typedOptionFn[S ~uint32] func(*State)
+
+func (fn typedOptionFn[S]) apply(s *State) {
+	fn(s)
+}
+func (typedOptionFn[S]) typed(S) {}
+
+// TypedOption adapts an option that doesn't take states (e.g. History, Logger or Instrument) to a Machine.
+func TypedOption[S ~uint32](o option) MachineOption[S] {
+	return typedOptionFn[S](o.apply)
+}
+
+// TypedStateNames is the type-safe version of StateNames, keyed by the caller's own state type.
+type TypedStateNames[S ~uint32] map[S]string
+
+func (m TypedStateNames[S]) apply(s *State) {
+	for v, name := range m {
+		s.stateNames[uint32(v)] = name
+	}
+}
+func (TypedStateNames[S]) typed(S) {}
+
+// TypedInitialState is the type-safe version of InitialState.
+func TypedInitialState[S ~uint32](v S) MachineOption[S] {
+	return typedOptionFn[S](InitialState(uint32(v)).apply)
+}
+
+// TypedEvents is the type-safe version of Events.
+type TypedEvents[S ~uint32] map[S]map[string]S
+
+func (m TypedEvents[S]) apply(s *State) {
+	events := make(Events, len(m))
+	for src, byEvent := range m {
+		events[uint32(src)] = make(map[string]uint32, len(byEvent))
+		for event, dst := range byEvent {
+			events[uint32(src)][event] = uint32(dst)
+		}
+	}
+	events.apply(s)
+}
+func (TypedEvents[S]) typed(S) {}
+
+// TypedSubstates is the type-safe version of Substates.
+func TypedSubstates[S ~uint32](parent S, children ...S) MachineOption[S] {
+	return typedOptionFn[S](Substates(uint32(parent), untypedStates(children)...).apply)
+}
+
+// TypedFinalStates is the type-safe version of FinalStates.
+func TypedFinalStates[S ~uint32](v ...S) MachineOption[S] {
+	return typedOptionFn[S](FinalStates(untypedStates(v)...).apply)
+}
+
+func untypedStates[S ~uint32](v []S) []uint32 {
+	u := make([]uint32, len(v))
+	for i, s := range v {
+		u[i] = uint32(s)
+	}
+	return u
+}
+
+// TypedCallback is the type-safe version of Callback.
+type TypedCallback[S ~uint32] func(src, dst S)
+
+func (fn TypedCallback[S]) untyped() Callback {
+	return func(src, dst uint32) { fn(S(src), S(dst)) }
+}
+
+// TypedGuardFunc is the type-safe version of GuardFunc.
+type TypedGuardFunc[S ~uint32] func(src, dst S) error
+
+// TypedOnEnter is the type-safe version of OnEnter.
+func TypedOnEnter[S ~uint32](v S, fn TypedCallback[S]) MachineOption[S] {
+	return typedOptionFn[S](OnEnter(uint32(v), fn.untyped()).apply)
+}
+
+// TypedOnExit is the type-safe version of OnExit.
+func TypedOnExit[S ~uint32](v S, fn TypedCallback[S]) MachineOption[S] {
+	return typedOptionFn[S](OnExit(uint32(v), fn.untyped()).apply)
+}
+
+// TypedOnTransition is the type-safe version of OnTransition.
+func TypedOnTransition[S ~uint32](fn TypedCallback[S]) MachineOption[S] {
+	return typedOptionFn[S](OnTransition(fn.untyped()).apply)
+}
+
+// TypedGuard is the type-safe version of Guard.
+func TypedGuard[S ~uint32](src, dst S, fn TypedGuardFunc[S]) MachineOption[S] {
+	return typedOptionFn[S](Guard(uint32(src), uint32(dst), func(src, dst uint32) error {
+		return fn(S(src), S(dst))
+	}).apply)
+}
+
+// TypedTransitionError is the type-safe version of TransitionError, returned by Machine.
+//
+// The underlying *TransitionError is available through errors.As.
+type TypedTransitionError[S ~uint32] struct {
+	Src, Dst S
+	err      *TransitionError
+}
+
+func (f *TypedTransitionError[S]) SrcName() string {
+	return f.err.SrcName()
+}
+func (f *TypedTransitionError[S]) DstName() string {
+	return f.err.DstName()
+}
+func (f *TypedTransitionError[S]) Error() string {
+	return f.err.Error()
+}
+func (f *TypedTransitionError[S]) Unwrap() error {
+	return f.err
+}
+
+// Machine is a type-safe wrapper of State, that only accepts states of type S.
+//
+// It shares the lock-free core of State, so all transitions are still done using a single compare-and-swap.
+type Machine[S ~uint32] struct {
+	s *State
+}
+
+// NewMachine creates a new type-safe State Machine.
+func NewMachine[S ~uint32](m TypedConstraints[S], opts ...MachineOption[S]) *Machine[S] {
+	untyped := make([]option, len(opts))
+	for i, o := range opts {
+		untyped[i] = o
+	}
+	return &Machine[S]{NewState(m.untyped(), untyped...)}
+}
+
+// State returns the underlying (untyped) state machine.
+func (m *Machine[S]) State() *State {
+	return m.s
+}
+
+// Current returns the current state.
+func (m *Machine[S]) Current() S {
+	return S(m.s.Current())
+}
+
+// CurrentName returns the alias for the current state.
+// If no alias is defined, the state integer will be returned in its string version.
+func (m *Machine[S]) CurrentName() string {
+	return m.s.CurrentName()
+}
+
+// TransitionFrom tries to change the state.
+// Returns a *TypedTransitionError if the transition failed.
+func (m *Machine[S]) TransitionFrom(src, dst S) error {
+	return m.typedErr(m.s.TransitionFrom(uint32(src), uint32(dst)))
+}
+
+// Transition tries to change the state.
+// It uses the current state as the source state, if you want to specify the source state use TransitionFrom instead.
+// Returns a *TypedTransitionError if the transition failed.
+func (m *Machine[S]) Transition(dst S) error {
+	return m.typedErr(m.s.Transition(uint32(dst)))
+}
+
+// String returns the Graphviz representation of this state machine.
+func (m *Machine[S]) String() string {
+	return m.s.String()
+}
+
+func (m *Machine[S]) typedErr(err error) error {
+	var tErr *TransitionError
+	if !errors.As(err, &tErr) {
+		return err
+	}
+	return &TypedTransitionError[S]{S(tErr.Src), S(tErr.Dst), tErr}
+}
@@ -0,0 +1,106 @@
+package lfsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrUnknownState reports an attempt to restore a state that doesn't appear in the transitions.
+var ErrUnknownState = errors.New("unknown state")
+
+// MarshalBinary implements encoding.BinaryMarshaler, it encodes the current state as a 4 byte big-endian integer.
+func (s *State) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint32(nil, s.Current()), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, see Restore.
+func (s *State) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("lfsm: invalid binary state length %d", len(data))
+	}
+	return s.Restore(binary.BigEndian.Uint32(data))
+}
+
+// MarshalText implements encoding.TextMarshaler, it encodes the alias of the current state (or the state integer if
+// no alias is defined).
+func (s *State) MarshalText() ([]byte, error) {
+	return []byte(s.CurrentName()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it accepts a state alias or a state integer, see Restore.
+func (s *State) UnmarshalText(text []byte) error {
+	v, ok := s.stateNames.lookup(string(text))
+	if !ok {
+		n, err := strconv.ParseUint(string(text), 10, 32)
+		if err != nil {
+			return fmt.Errorf("lfsm: %w %q", ErrUnknownState, text)
+		}
+		v = uint32(n)
+	}
+	return s.Restore(v)
+}
+
+// MarshalJSON implements json.Marshaler, it encodes the alias of the current state as a JSON string, or the state
+// integer as a JSON number if no alias is defined.
+func (s *State) MarshalJSON() ([]byte, error) {
+	v := s.Current()
+	if name, ok := s.stateNames[v]; ok {
+		return json.Marshal(name)
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, it accepts the output of MarshalJSON, see Restore.
+// Like in encoding/json, a JSON null is a no-op.
+func (s *State) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	var v uint32
+	if err := json.Unmarshal(data, &v); err == nil {
+		return s.Restore(v)
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("lfsm: invalid JSON state: %w", err)
+	}
+	return s.UnmarshalText([]byte(text))
+}
+
+// Restore sets the current state to a previously persisted state v, which must appear in the transitions.
+//
+// Restoring is not a transition: it is not validated against the Constraints, and callbacks, guards and subscribers
+// are not called. It does start a new generation, wakes up waiters and closes Done if v is a final state.
+// If a Journal is configured, the restore is appended to it as a Restored entry, so Replay can reproduce it.
+// The initial state is not changed, so String still renders the real initial state.
+func (s *State) Restore(v uint32) error {
+	if !s.known()[v] {
+		return fmt.Errorf("lfsm: %w %s", ErrUnknownState, s.stateNames.find(v))
+	}
+	var old, word uint64
+	for {
+		old = s.current.Load()
+		word = nextWord(old, v)
+		if s.current.CompareAndSwap(old, word) {
+			break
+		}
+	}
+	if s.journal != nil {
+		s.journal.add(HistoryEntry{
+			Src:        uint32(old),
+			Dst:        v,
+			Generation: uint32(word >> 32),
+			Time:       s.clock.Now(),
+			Restored:   true,
+		})
+	}
+	s.instrument()
+	s.arm(word)
+	s.reached(v)
+	s.broadcast()
+	return nil
+}
@@ -0,0 +1,270 @@
+package lfsm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome is the result of a transition attempt.
+type Outcome int
+
+const (
+	// OutcomeSuccess reports a successful transition.
+	OutcomeSuccess Outcome = iota
+	// OutcomeInvalid reports a transition (or event) that is not defined, see NewInvalidTransitionError.
+	OutcomeInvalid
+	// OutcomeFailed reports a transition that lost the compare-and-swap race, see NewFailedTransitionError.
+	OutcomeFailed
+	// OutcomeRejected reports a transition that was vetoed by a guard, see GuardRejectedError.
+	OutcomeRejected
+	// OutcomeFinal reports a transition attempt out of a final state, see FinalStateError.
+	OutcomeFinal
+	// OutcomeStale reports a transition from a stale snapshot, see StaleSnapshotError.
+	OutcomeStale
+)
+
+var outcomeNames = [...]string{"success", "invalid", "failed", "rejected", "final", "stale"}
+
+func (o Outcome) String() string {
+	if o < 0 || int(o) >= len(outcomeNames) {
+		return fmt.Sprintf("Outcome(%d)", int(o))
+	}
+	return outcomeNames[o]
+}
+
+// outcomeOf classifies the error of a transition attempt.
+func outcomeOf(err error) Outcome {
+	switch e := err.(type) {
+	case nil:
+		return OutcomeSuccess
+	case *TransitionError:
+		return e.outcome()
+	case *GuardRejectedError:
+		return OutcomeRejected
+	case *FinalStateError:
+		return OutcomeFinal
+	case *StaleSnapshotError:
+		return OutcomeStale
+	}
+	return OutcomeInvalid
+}
+
+// Metrics receives the instrumentation of a state machine, see Instrument.
+//
+// The methods are called synchronously from the transitioning goroutines, so implementations must be safe for
+// concurrent use, and should be fast.
+type Metrics interface {
+	// ObserveTransition is called after every transition attempt from src to dst.
+	ObserveTransition(src, dst uint32, outcome Outcome)
+	// ObserveStateDuration is called after the state machine leaves state v, that it entered d earlier.
+	// Under contention, the durations of consecutive states may be slightly skewed.
+	ObserveStateDuration(v uint32, d time.Duration)
+	// SetState is called with the current state when the state machine is created, after every successful
+	// transition and when it is restored.
+	//
+	// ObserveStateDuration and SetState are called in the order of the transition generations (see Snapshot), even
+	// if the transitions of concurrent goroutines complete out of order.
+	SetState(v uint32)
+}
+
+// Instrument reports every transition attempt, and the time spent in every state, to m.
+func Instrument(m Metrics) option {
+	return optionFn(func(s *State) {
+		s.metrics = m
+	})
+}
+
+// gauge tracks the current state of the metrics, it orders the successful transitions by their generations like
+// the journal does.
+type gauge struct {
+	mu      sync.Mutex
+	last    uint32
+	entered time.Time
+	pending map[uint32]gaugeEntry
+}
+
+type gaugeEntry struct {
+	src, dst uint32
+	at       time.Time
+}
+
+// instrument resets the current state of the metrics, if they are enabled.
+func (s *State) instrument() {
+	if s.metrics == nil {
+		return
+	}
+	s.gauge.mu.Lock()
+	defer s.gauge.mu.Unlock()
+	word := s.current.Load()
+	s.gauge.last = uint32(word >> 32)
+	s.gauge.entered = s.clock.Now()
+	s.gauge.pending = make(map[uint32]gaugeEntry)
+	s.metrics.SetState(uint32(word))
+}
+
+// enter reports the time spent in the source state, and the new current state, of a successful transition that
+// stored word, once all the preceding generations were reported.
+func (s *State) enter(a attempt, word uint64) {
+	if s.metrics == nil {
+		return
+	}
+	s.gauge.mu.Lock()
+	defer s.gauge.mu.Unlock()
+	gen := uint32(word >> 32)
+	if int32(gen-s.gauge.last) <= 0 {
+		return // The state machine was restored since.
+	}
+	s.gauge.pending[gen] = gaugeEntry{a.src, a.dst, s.clock.Now()}
+	for {
+		next, ok := s.gauge.pending[s.gauge.last+1]
+		if !ok {
+			return
+		}
+		delete(s.gauge.pending, s.gauge.last+1)
+		s.gauge.last++
+		d := next.at.Sub(s.gauge.entered)
+		if d < 0 {
+			d = 0
+		}
+		s.gauge.entered = next.at
+		s.metrics.ObserveStateDuration(next.src, d)
+		s.metrics.SetState(next.dst)
+	}
+}
+
+// observe reports the outcome of a transition attempt, if metrics are enabled.
+func (s *State) observe(a attempt, err error) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.ObserveTransition(a.src, a.dst, outcomeOf(err))
+}
+
+// PrometheusMetrics is an in-memory Metrics implementation, that can be exposed in the Prometheus text format.
+type PrometheusMetrics struct {
+	namespace  string
+	stateNames StateNames
+
+	mu          sync.Mutex
+	transitions map[[2]uint32]uint64
+	outcomes    [len(outcomeNames)]uint64
+	durations   map[uint32]*durationSummary
+	current     uint32
+	states      map[uint32]bool
+}
+
+type durationSummary struct {
+	sum   time.Duration
+	count uint64
+}
+
+// NewPrometheusMetrics creates a PrometheusMetrics, whose metric names are prefixed with namespace ("lfsm" if empty)
+// and whose state labels are taken from stateNames.
+func NewPrometheusMetrics(namespace string, stateNames StateNames) *PrometheusMetrics {
+	if namespace == "" {
+		namespace = "lfsm"
+	}
+	m := &PrometheusMetrics{
+		namespace:   namespace,
+		stateNames:  make(StateNames, len(stateNames)),
+		transitions: make(map[[2]uint32]uint64),
+		durations:   make(map[uint32]*durationSummary),
+		states:      make(map[uint32]bool, len(stateNames)),
+	}
+	for v, name := range stateNames {
+		m.stateNames[v] = name
+		m.states[v] = true
+	}
+	return m
+}
+
+// ObserveTransition implements Metrics.
+func (m *PrometheusMetrics) ObserveTransition(src, dst uint32, outcome Outcome) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if outcome >= 0 && int(outcome) < len(m.outcomes) {
+		m.outcomes[outcome]++
+	}
+	if outcome == OutcomeSuccess {
+		m.transitions[[2]uint32{src, dst}]++
+	}
+}
+
+// ObserveStateDuration implements Metrics.
+func (m *PrometheusMetrics) ObserveStateDuration(v uint32, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	summary, ok := m.durations[v]
+	if !ok {
+		summary = &durationSummary{}
+		m.durations[v] = summary
+	}
+	summary.sum += d
+	summary.count++
+}
+
+// SetState implements Metrics.
+func (m *PrometheusMetrics) SetState(v uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = v
+	m.states[v] = true
+}
+
+// WritePrometheus writes all the metrics to w, in the Prometheus text exposition format.
+//
+// See: https://prometheus.io/docs/instrumenting/exposition_formats/
+func (m *PrometheusMetrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ew := &exportWriter{w: w}
+	label := func(v uint32) string {
+		return promEscape(m.stateNames.find(v))
+	}
+
+	name := m.namespace + "_transitions_total"
+	ew.printf("# HELP %s Successful transitions per edge.\n# TYPE %s counter\n", name, name)
+	edges := make([][2]uint32, 0, len(m.transitions))
+	for edge := range m.transitions {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i][0] < edges[j][0] || (edges[i][0] == edges[j][0] && edges[i][1] < edges[j][1])
+	})
+	for _, edge := range edges {
+		ew.printf("%s{src=\"%s\",dst=\"%s\"} %d\n", name, label(edge[0]), label(edge[1]), m.transitions[edge])
+	}
+
+	name = m.namespace + "_transition_attempts_total"
+	ew.printf("# HELP %s Transition attempts per outcome.\n# TYPE %s counter\n", name, name)
+	for outcome, count := range m.outcomes {
+		ew.printf("%s{outcome=\"%s\"} %d\n", name, Outcome(outcome), count)
+	}
+
+	name = m.namespace + "_state_duration_seconds"
+	ew.printf("# HELP %s Time spent in each state.\n# TYPE %s summary\n", name, name)
+	for _, v := range sortedKeys(m.durations) {
+		ew.printf("%s_sum{state=\"%s\"} %g\n", name, label(v), m.durations[v].sum.Seconds())
+		ew.printf("%s_count{state=\"%s\"} %d\n", name, label(v), m.durations[v].count)
+	}
+
+	name = m.namespace + "_state"
+	ew.printf("# HELP %s Whether the state machine is in each state.\n# TYPE %s gauge\n", name, name)
+	for _, v := range sortedKeys(m.states) {
+		value := 0
+		if v == m.current {
+			value = 1
+		}
+		ew.printf("%s{state=\"%s\"} %d\n", name, label(v), value)
+	}
+	return ew.err
+}
+
+// promEscape escapes text for a Prometheus label value.
+func promEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(text)
+}
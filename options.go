@@ -10,11 +10,22 @@ func (o optionFn) apply(s *State) {
 	o(s)
 }
 
+// Can be used to alter the 64 bit state struct during initialization.
+type option64 interface {
+	apply64(s *State64)
+}
+
+type optionFn64 func(s *State64)
+
+func (o optionFn64) apply64(s *State64) {
+	o(s)
+}
+
 // InitialState sets the initial state of the state machine
 func InitialState(v uint32) option {
 	return optionFn(func(s *State) {
 		s.initial = v
-		s.current = v
+		s.current.Store(uint64(v))
 	})
 }
 
@@ -24,3 +35,80 @@ func StateName(v uint32, name string) option {
 		s.stateNames[v] = name
 	})
 }
+
+// InitialState64 sets the initial state of the 64 bit state machine
+func InitialState64(v uint64) option64 {
+	return optionFn64(func(s *State64) {
+		s.initial = v
+		s.current.Store(v)
+	})
+}
+
+// StateName64 sets an alias to a 64 bit state integer.
+func StateName64(v uint64, name string) option64 {
+	return optionFn64(func(s *State64) {
+		s.stateNames[v] = name
+	})
+}
+
+// OnEnter registers a callback that is called every time the state machine enters state v.
+//
+// Callbacks are called exactly once for every successful transition, after the state was already changed, in the
+// goroutine that performed the transition. Per transition the order is: OnExit callbacks of the source state, OnEnter
+// callbacks of the destination state and then OnTransition callbacks, each group in registration order.
+//
+// Since no locks are held while the callbacks run, the state may have already changed again by the time a callback
+// is called, and callbacks of concurrent transitions may interleave. Callbacks may safely trigger new transitions.
+func OnEnter(v uint32, fn Callback) option {
+	return optionFn(func(s *State) {
+		if s.onEnter == nil {
+			s.onEnter = make(map[uint32][]Callback)
+		}
+		s.onEnter[v] = append(s.onEnter[v], fn)
+	})
+}
+
+// OnExit registers a callback that is called every time the state machine leaves state v.
+//
+// See OnEnter for the ordering and concurrency guarantees.
+func OnExit(v uint32, fn Callback) option {
+	return optionFn(func(s *State) {
+		if s.onExit == nil {
+			s.onExit = make(map[uint32][]Callback)
+		}
+		s.onExit[v] = append(s.onExit[v], fn)
+	})
+}
+
+// OnTransition registers a callback that is called after every successful transition.
+//
+// See OnEnter for the ordering and concurrency guarantees.
+func OnTransition(fn Callback) option {
+	return optionFn(func(s *State) {
+		s.onTransition = append(s.onTransition, fn)
+	})
+}
+
+// Guard registers a predicate that is evaluated before every transition from src to dst.
+//
+// Guards are evaluated in registration order, before the compare-and-swap, and the first guard that returns an error
+// rejects the transition with a *GuardRejectedError. Since no locks are held, the state may change between the guard
+// evaluation and the compare-and-swap, in which case the transition fails as usual.
+func Guard(src, dst uint32, fn GuardFunc) option {
+	return optionFn(func(s *State) {
+		if s.guards == nil {
+			s.guards = make(map[uint32]map[uint32][]GuardFunc)
+		}
+		if s.guards[src] == nil {
+			s.guards[src] = make(map[uint32][]GuardFunc)
+		}
+		s.guards[src][dst] = append(s.guards[src][dst], fn)
+	})
+}
+
+// SlowSubscribers sets the policy for subscribers (see State.Subscribe) that don't keep up with the state changes.
+func SlowSubscribers(p OverflowPolicy) option {
+	return optionFn(func(s *State) {
+		s.overflow = p
+	})
+}
@@ -0,0 +1,200 @@
+package lfsm
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Region describes an independent sub-machine of a Regions state machine.
+type Region struct {
+	Name        string
+	Constraints Constraints
+	StateNames  StateNames
+	Initial     uint32
+}
+
+type region struct {
+	name        string
+	transitions transitionMap
+	stateNames  StateNames
+	shift, bits uint
+}
+
+func (r *region) get(word uint64) uint32 {
+	return uint32(word>>r.shift) & r.mask()
+}
+
+func (r *region) set(word uint64, v uint32) uint64 {
+	return word&^(uint64(r.mask())<<r.shift) | uint64(v)<<r.shift
+}
+
+func (r *region) mask() uint32 {
+	return 1<<r.bits - 1
+}
+
+// Regions is a state machine made of several orthogonal regions, each with its own Constraints.
+//
+// The states of all the regions are packed into a single 64 bit word, so transitions that change several regions at
+// once are still done using a single compare-and-swap.
+type Regions struct {
+	current atomic.Uint64
+	regions []region
+}
+
+// NewRegions creates a new multi-region State Machine.
+// Every region gets as many bits as its largest state requires, NewRegions panics if they don't fit into 64 bits.
+func NewRegions(regions ...Region) *Regions {
+	rs := &Regions{regions: make([]region, len(regions))}
+	var shift uint
+	var word uint64
+	for i, r := range regions {
+		max := r.Initial
+		transitions := make(transitionMap, len(r.Constraints))
+		for src, dsts := range r.Constraints {
+			transitions[src] = make(map[uint32]bool)
+			if src > max {
+				max = src
+			}
+			for _, dst := range dsts {
+				transitions[src][dst] = true
+				if dst > max {
+					max = dst
+				}
+			}
+		}
+		stateNames := make(StateNames, len(r.StateNames))
+		for v, name := range r.StateNames {
+			stateNames[v] = name
+		}
+
+		size := uint(bits.Len32(max))
+		if size == 0 {
+			size = 1
+		}
+		rs.regions[i] = region{r.Name, transitions, stateNames, shift, size}
+		shift += size
+		if shift > 64 {
+			panic(fmt.Sprintf("lfsm: regions require %d bits, only 64 are available", shift))
+		}
+		word = rs.regions[i].set(word, r.Initial)
+	}
+	rs.current.Store(word)
+	return rs
+}
+
+// Current returns the current state of every region.
+func (rs *Regions) Current() []uint32 {
+	return rs.unpack(rs.current.Load())
+}
+
+// CurrentOf returns the current state of the i-th region.
+func (rs *Regions) CurrentOf(i int) uint32 {
+	return rs.regions[i].get(rs.current.Load())
+}
+
+// CurrentNames returns the alias of the current state of every region.
+func (rs *Regions) CurrentNames() []string {
+	word := rs.current.Load()
+	names := make([]string, len(rs.regions))
+	for i := range rs.regions {
+		names[i] = rs.regions[i].stateNames.find(rs.regions[i].get(word))
+	}
+	return names
+}
+
+// TransitionFrom tries to change the states of all the regions at once.
+// src and dst hold a state per region, regions where src and dst are equal are left unchanged (but their state must
+// still match src).
+// Returns a *TransitionError of the first offending region if the transition failed.
+func (rs *Regions) TransitionFrom(src, dst []uint32) error {
+	if len(src) != len(rs.regions) || len(dst) != len(rs.regions) {
+		return fmt.Errorf("lfsm: expected %d region states, got %d -> %d", len(rs.regions), len(src), len(dst))
+	}
+	var old, next uint64
+	for i := range rs.regions {
+		r := &rs.regions[i]
+		if src[i] > r.mask() || (src[i] != dst[i] && !r.transitions[src[i]][dst[i]]) {
+			return r.err(NewInvalidTransitionError(src[i], dst[i], r.stateNames))
+		}
+		old = r.set(old, src[i])
+		next = r.set(next, dst[i])
+	}
+	if !rs.current.CompareAndSwap(old, next) {
+		observed := rs.current.Load()
+		for i := range rs.regions {
+			if v := rs.regions[i].get(observed); v != src[i] {
+				return rs.regions[i].err(NewStaleSourceError(src[i], dst[i], v, rs.regions[i].stateNames))
+			}
+		}
+		// The word changed and changed back since it was loaded, so the observed state matches the source again.
+		return rs.regions[0].err(NewStaleSourceError(src[0], dst[0], rs.regions[0].get(observed), rs.regions[0].stateNames))
+	}
+	return nil
+}
+
+// Transition tries to change the states of all the regions at once.
+// It uses the current states as the source states, if you want to specify the source states use TransitionFrom
+// instead.
+func (rs *Regions) Transition(dst []uint32) error {
+	return rs.TransitionFrom(rs.Current(), dst)
+}
+
+// TransitionRegion tries to change the state of the i-th region only.
+// Concurrent changes to the other regions don't fail the transition.
+func (rs *Regions) TransitionRegion(i int, dst uint32) error {
+	r := &rs.regions[i]
+	for {
+		old := rs.current.Load()
+		src := r.get(old)
+		if !r.transitions[src][dst] {
+			return r.err(NewInvalidTransitionError(src, dst, r.stateNames))
+		}
+		if rs.current.CompareAndSwap(old, r.set(old, dst)) {
+			return nil
+		}
+	}
+}
+
+// String returns the Graphviz representation of this state machine, with a cluster per region.
+// Like WriteDOT, the output is deterministic: states and transitions are sorted.
+func (rs *Regions) String() string {
+	buf := &bytes.Buffer{}
+	word := rs.current.Load()
+	_, _ = fmt.Fprint(buf, "digraph g{")
+	for i := range rs.regions {
+		r := &rs.regions[i]
+		_, _ = fmt.Fprintf(buf, `subgraph cluster_r%d{label="%s";`, i, dotEscape(r.name))
+		for _, v := range sortedKeys(r.stateNames) {
+			if v == r.get(word) {
+				_, _ = fmt.Fprintf(buf, `r%dn%d[label="%s",style=filled];`, i, v, dotEscape(r.stateNames[v]))
+			} else {
+				_, _ = fmt.Fprintf(buf, `r%dn%d[label="%s"];`, i, v, dotEscape(r.stateNames[v]))
+			}
+		}
+		for _, src := range sortedKeys(r.transitions) {
+			for _, dst := range sortedKeys(r.transitions[src]) {
+				_, _ = fmt.Fprintf(buf, "r%dn%d->r%dn%d;", i, src, i, dst)
+			}
+		}
+		_, _ = fmt.Fprint(buf, "}")
+	}
+	_, _ = fmt.Fprint(buf, "}")
+	return buf.String()
+}
+
+func (rs *Regions) unpack(word uint64) []uint32 {
+	states := make([]uint32, len(rs.regions))
+	for i := range rs.regions {
+		states[i] = rs.regions[i].get(word)
+	}
+	return states
+}
+
+func (r *region) err(tErr *TransitionError) *TransitionError {
+	if r.name != "" {
+		tErr.msg = fmt.Sprintf("region %s: %s", r.name, tErr.msg)
+	}
+	return tErr
+}
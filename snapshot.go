@@ -0,0 +1,44 @@
+package lfsm
+
+// Snapshot is the state of the state machine at a specific point in time.
+//
+// Every successful transition increments the generation, so a Snapshot identifies a single visit to a state, even if
+// the state machine left the state and returned to it since (the ABA problem). The generation is a 32 bit counter that
+// wraps around, so two snapshots are only distinguishable if fewer than 2^32 transitions happened between them.
+type Snapshot struct {
+	State      uint32
+	Generation uint32
+}
+
+func (snap Snapshot) word() uint64 {
+	return uint64(snap.Generation)<<32 | uint64(snap.State)
+}
+
+// Snapshot returns the current state along with its generation.
+func (s *State) Snapshot() Snapshot {
+	word := s.current.Load()
+	return Snapshot{uint32(word), uint32(word >> 32)}
+}
+
+// TransitionFromSnapshot tries to change the state from the snapshot state to dst.
+// Unlike TransitionFrom, it fails with a *StaleSnapshotError if any transition happened since the snapshot was taken,
+// even if the state machine returned to the snapshot state.
+func (s *State) TransitionFromSnapshot(snap Snapshot, dst uint32) error {
+	return s.transitionFromSnapshot(snap, attempt{src: snap.State, dst: dst})
+}
+
+func (s *State) transitionFromSnapshot(snap Snapshot, a attempt) error {
+	return s.run(a, func() error { return s.trySnapshot(snap, a) })
+}
+
+func (s *State) trySnapshot(snap Snapshot, a attempt) error {
+	if err := s.check(a.src, a.dst); err != nil {
+		return err
+	}
+	word := nextWord(snap.word(), a.dst)
+	if !s.current.CompareAndSwap(snap.word(), word) {
+		return NewStaleSnapshotError(snap, a.dst, s.Snapshot(), s.stateNames)
+	}
+	s.committed(a, word)
+	return nil
+}
@@ -1,7 +1,10 @@
 package lfsm
 
 import (
+	"context"
+	"log/slog"
 	"strconv"
+	"sync"
 	"sync/atomic"
 )
 
@@ -9,48 +12,210 @@ type transitionMap map[uint32]map[uint32]bool
 
 // State is the structs that holds the current state, the available transitions and other options.
 type State struct {
-	current     uint32
+	// current packs a generation counter (high 32 bits) with the state (low 32 bits), see Snapshot.
+	current     atomic.Uint64
 	transitions transitionMap
 	stateNames  StateNames
 	initial     uint32
+
+	onEnter      map[uint32][]Callback
+	onExit       map[uint32][]Callback
+	onTransition []Callback
+	guards       map[uint32]map[uint32][]GuardFunc
+	events       Events
+	parents      map[uint32]uint32
+	final        map[uint32]bool
+
+	done     chan struct{}
+	doneOnce sync.Once
+
+	history *history
+	journal *journal
+
+	metrics Metrics
+	gauge   gauge
+	tracer  Tracer
+
+	logger    *slog.Logger
+	logLevels [2]slog.Level
+
+	clock    Clock
+	timeouts map[uint32]timeout
+	timers   timers
+
+	waiters int32
+	changed atomic.Value
+
+	seq         atomic.Uint64
+	subscribers atomic.Pointer[[]*subscriber]
+	subMu       sync.Mutex
+	overflow    OverflowPolicy
 }
 
+// Callback is a function that is called after a successful transition from src to dst.
+type Callback func(src, dst uint32)
+
+// GuardFunc is a predicate that can veto a transition from src to dst by returning a non-nil error.
+type GuardFunc func(src, dst uint32) error
+
 // Current returns the current state.
 func (s *State) Current() uint32 {
-	return atomic.LoadUint32(&s.current)
+	return uint32(s.current.Load())
 }
 
 // CurrentName returns the alias for the current state.
 // If no alias is defined, the state integer will be returned in its string version.
 func (s *State) CurrentName() string {
-	return s.stateNames.find(atomic.LoadUint32(&s.current))
+	return s.stateNames.find(s.Current())
 }
 
 // TransitionFrom tries to change the state.
 // Returns an error if the transition failed.
 func (s *State) TransitionFrom(src, dst uint32) error {
-	if _, ok := s.transitions[src][dst]; !ok {
+	return s.transitionFrom(attempt{src: src, dst: dst})
+}
+
+// TransitionFromTagged is like TransitionFrom, but it also records tag in the History of the transition.
+func (s *State) TransitionFromTagged(src, dst uint32, tag string) error {
+	return s.transitionFrom(attempt{src: src, dst: dst, tag: tag})
+}
+
+// attempt describes a single transition attempt.
+type attempt struct {
+	ctx      context.Context
+	src, dst uint32
+	event    string
+	tag      string
+}
+
+func (s *State) transitionFrom(a attempt) error {
+	return s.run(a, func() error { return s.try(a) })
+}
+
+// run performs a transition attempt using try, and reports its outcome to the tracer and the metrics.
+func (s *State) run(a attempt, try func() error) error {
+	var end func(Outcome, error)
+	if s.tracer != nil {
+		if a.ctx == nil {
+			a.ctx = context.Background()
+		}
+		end = s.tracer.StartTransition(a.ctx, a.src, a.dst)
+	}
+	err := try()
+	s.observe(a, err)
+	s.log(a, err)
+	if end != nil {
+		end(outcomeOf(err), err)
+	}
+	return err
+}
+
+// try performs a single transition attempt.
+func (s *State) try(a attempt) error {
+	if err := s.check(a.src, a.dst); err != nil {
+		return err
+	}
+	var word uint64
+	for {
+		old := s.current.Load()
+		if uint32(old) != a.src {
+			return NewStaleSourceError(a.src, a.dst, uint32(old), s.stateNames)
+		}
+		word = nextWord(old, a.dst)
+		if s.current.CompareAndSwap(old, word) {
+			break
+		}
+	}
+	s.committed(a, word)
+	return nil
+}
+
+// check validates that the src -> dst transition is defined, and that none of its guards reject it.
+func (s *State) check(src, dst uint32) error {
+	if s.final[src] {
+		return NewFinalStateError(src, dst, s.stateNames)
+	}
+	chain, ok := s.allowed(src, dst)
+	if !ok {
 		return NewInvalidTransitionError(src, dst, s.stateNames)
 	}
-	if !atomic.CompareAndSwapUint32(&s.current, src, dst) {
-		return NewFailedTransitionError(src, dst, s.stateNames)
+	if err := s.guard(src, src, dst); err != nil {
+		return err
+	}
+	for i := 1; i < len(chain); i++ {
+		if err := s.guard(chain[i], src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextWord returns the packed word of a transition from word to dst, with the next generation.
+func nextWord(word uint64, dst uint32) uint64 {
+	return (word>>32+1)<<32 | uint64(dst)
+}
+
+// guard evaluates the guards that were registered for the v -> dst transition.
+func (s *State) guard(v, src, dst uint32) error {
+	for _, guard := range s.guards[v][dst] {
+		if err := guard(src, dst); err != nil {
+			return NewGuardRejectedError(src, dst, err, s.stateNames)
+		}
 	}
 	return nil
 }
 
+// committed runs the callbacks of a successful transition, in the goroutine that won the compare-and-swap.
+// word is the packed state word that the transition stored.
+func (s *State) committed(a attempt, word uint64) {
+	src, dst := a.src, a.dst
+	seq := s.seq.Add(1)
+	s.reached(dst)
+	s.broadcast()
+	s.arm(word)
+	s.enter(a, word)
+	s.record(a, word)
+	for _, fn := range s.onExit[src] {
+		fn(src, dst)
+	}
+	for _, fn := range s.onEnter[dst] {
+		fn(src, dst)
+	}
+	for _, fn := range s.onTransition {
+		fn(src, dst)
+	}
+	s.notify(src, dst, word, seq)
+}
+
 // Transition tries to change the state.
 // It uses the current state as the source state, if you want to specify the source state use TransitionFrom instead.
 // Returns an error if the transition failed.
 func (s *State) Transition(dst uint32) error {
-	return s.TransitionFrom(atomic.LoadUint32(&s.current), dst)
+	return s.TransitionFrom(s.Current(), dst)
+}
+
+// TransitionTagged is like Transition, but it also records tag in the History of the transition.
+func (s *State) TransitionTagged(dst uint32, tag string) error {
+	return s.TransitionFromTagged(s.Current(), dst, tag)
 }
 
 // NewState creates a new State Machine.
 func NewState(m Constraints, opts ...option) *State {
+	s := newState(m, opts...)
+	s.start()
+	return s
+}
+
+// newState creates a new State Machine and applies its options, without any side effects.
+func newState(m Constraints, opts ...option) *State {
 	s := State{
 		transitions: make(transitionMap, len(m)),
 		stateNames: make(StateNames, len(m)),
+		done: make(chan struct{}),
+		logLevels: [2]slog.Level{slog.LevelInfo, slog.LevelWarn},
+		clock: systemClock{},
 	}
+	s.changed.Store(make(chan struct{}))
 
 	for src, dsts := range m {
 		s.transitions[src] = make(map[uint32]bool)
@@ -66,6 +231,13 @@ func NewState(m Constraints, opts ...option) *State {
 	return &s
 }
 
+// start enters the initial state: it closes Done for a final state, resets the metrics and arms the timeout.
+func (s *State) start() {
+	s.reached(s.Current())
+	s.instrument()
+	s.arm(s.current.Load())
+}
+
 // Constraints defines the possible transition for this state machine.
 //
 // The map keys describe the source states, and their values are the valid target destinations.
@@ -80,6 +252,14 @@ func (m StateNames) find(v uint32) string {
 	}
 	return name
 }
+func (m StateNames) lookup(name string) (uint32, bool) {
+	for v, n := range m {
+		if n == name {
+			return v, true
+		}
+	}
+	return 0, false
+}
 func (m StateNames) apply(s *State) {
 	for v,name := range m {
 		s.stateNames[v] = name
@@ -0,0 +1,96 @@
+package lfsm
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+type transitionMap64 map[uint64]map[uint64]bool
+
+// State64 is the 64 bit variant of State, for large or bit-packed state spaces.
+//
+// It only supports the core State API, using a 64 bit compare-and-swap: the InitialState64 and StateName64 (or
+// StateNames64) options, TransitionError64 errors and the Graphviz output of String. The rest of the State features
+// (callbacks, guards, events, substates, final states, snapshots, waiting, subscriptions, instrumentation and
+// WriteDOT) require State, since the generation counter of its packed state word is what makes them safe.
+type State64 struct {
+	current     atomic.Uint64
+	transitions transitionMap64
+	stateNames  StateNames64
+	initial     uint64
+}
+
+// Current returns the current state.
+func (s *State64) Current() uint64 {
+	return s.current.Load()
+}
+
+// CurrentName returns the alias for the current state.
+// If no alias is defined, the state integer will be returned in its string version.
+func (s *State64) CurrentName() string {
+	return s.stateNames.find(s.current.Load())
+}
+
+// TransitionFrom tries to change the state.
+// Returns an error if the transition failed.
+func (s *State64) TransitionFrom(src, dst uint64) error {
+	if _, ok := s.transitions[src][dst]; !ok {
+		return NewInvalidTransitionError64(src, dst, s.stateNames)
+	}
+	for {
+		old := s.current.Load()
+		if old != src {
+			return NewStaleSourceError64(src, dst, old, s.stateNames)
+		}
+		if s.current.CompareAndSwap(old, dst) {
+			return nil
+		}
+	}
+}
+
+// Transition tries to change the state.
+// It uses the current state as the source state, if you want to specify the source state use TransitionFrom instead.
+// Returns an error if the transition failed.
+func (s *State64) Transition(dst uint64) error {
+	return s.TransitionFrom(s.current.Load(), dst)
+}
+
+// NewState64 creates a new 64 bit State Machine.
+func NewState64(m Constraints64, opts ...option64) *State64 {
+	s := State64{
+		transitions: make(transitionMap64, len(m)),
+		stateNames:  make(StateNames64, len(m)),
+	}
+
+	for src, dsts := range m {
+		s.transitions[src] = make(map[uint64]bool)
+		for _, dst := range dsts {
+			s.transitions[src][dst] = true
+		}
+	}
+
+	for _, o := range opts {
+		o.apply64(&s)
+	}
+
+	return &s
+}
+
+// Constraints64 is the 64 bit variant of Constraints.
+type Constraints64 map[uint64][]uint64
+
+// StateNames64 is the 64 bit variant of StateNames.
+type StateNames64 map[uint64]string
+
+func (m StateNames64) find(v uint64) string {
+	name, ok := m[v]
+	if !ok {
+		name = strconv.FormatUint(v, 10)
+	}
+	return name
+}
+func (m StateNames64) apply64(s *State64) {
+	for v, name := range m {
+		s.stateNames[v] = name
+	}
+}
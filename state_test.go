@@ -1,7 +1,17 @@
 package lfsm_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Eyal-Shalev/lfsm"
 )
@@ -26,18 +36,43 @@ func benchBigState(s *lfsm.State, b *testing.B) {
 	}
 }
 
-func newBigState(size uint32) *lfsm.State {
+func newBigConstraints(size uint32) lfsm.Constraints {
 	constraints := make(lfsm.Constraints, size)
 	for i := uint32(0); i < size; i++ {
 		constraints[i] = []uint32{(i + 1) % size}
 	}
-	return lfsm.NewState(constraints, lfsm.InitialState(size-1))
+	return constraints
+}
+
+func newBigState(size uint32) *lfsm.State {
+	return lfsm.NewState(newBigConstraints(size), lfsm.InitialState(size-1))
 }
 
 func BenchmarkState10(b *testing.B)   { benchBigState(newBigState(10), b) }
 func BenchmarkState100(b *testing.B)  { benchBigState(newBigState(100), b) }
 func BenchmarkState1000(b *testing.B) { benchBigState(newBigState(1000), b) }
 
+func benchBigState64(s *lfsm.State64, b *testing.B) {
+	size := s.Current() + 1
+	for n := 0; n < b.N; n++ {
+		for i := uint64(0); i < size; i++ {
+			logErr(b, s.Transition(i))
+		}
+	}
+}
+
+func newBigState64(size uint64) *lfsm.State64 {
+	constraints := make(lfsm.Constraints64, size)
+	for i := uint64(0); i < size; i++ {
+		constraints[i] = []uint64{(i + 1) % size}
+	}
+	return lfsm.NewState64(constraints, lfsm.InitialState64(size-1))
+}
+
+func BenchmarkState64_10(b *testing.B)   { benchBigState64(newBigState64(10), b) }
+func BenchmarkState64_100(b *testing.B)  { benchBigState64(newBigState64(100), b) }
+func BenchmarkState64_1000(b *testing.B) { benchBigState64(newBigState64(1000), b) }
+
 func TestIntermediateState(t *testing.T) {
 	s := lfsm.NewState(lfsm.Constraints{
 		0: {1},
@@ -73,3 +108,938 @@ func TestIntermediateState(t *testing.T) {
 		t.Error("Invalid transition error expected.")
 	}
 }
+
+func TestMachineTypedOptions(t *testing.T) {
+	type light uint32
+	const (
+		red light = iota
+		green
+		yellow
+	)
+	var entered, moved []light
+	m := lfsm.NewMachine(
+		lfsm.TypedConstraints[light]{red: {green}, green: {yellow}, yellow: {red}},
+		lfsm.TypedInitialState(yellow),
+		lfsm.TypedOnEnter(red, func(src, _ light) { entered = append(entered, src) }),
+		lfsm.TypedOnTransition(func(_, dst light) { moved = append(moved, dst) }),
+		lfsm.TypedGuard(red, green, func(_, _ light) error { return errors.New("stop") }),
+		lfsm.TypedOption[light](lfsm.History(4)),
+	)
+	fatalIfErr(t, m.Transition(red))
+	if err := m.Transition(green); !errors.Is(err, lfsm.ErrGuardRejected) {
+		t.Errorf("expected a guard rejection, got %v", err)
+	}
+	if len(entered) != 1 || entered[0] != yellow || len(moved) != 1 || moved[0] != red {
+		t.Errorf("unexpected callback calls: entered from %v, moved to %v", entered, moved)
+	}
+	if len(m.State().History()) != 1 {
+		t.Errorf("expected a single history entry, got %d", len(m.State().History()))
+	}
+}
+
+func TestMachineTypedError(t *testing.T) {
+	type light uint32
+	const (
+		red light = iota
+		green
+	)
+	m := lfsm.NewMachine(lfsm.TypedConstraints[light]{red: {green}, green: {red}})
+
+	err := m.Transition(red)
+	var tErr *lfsm.TypedTransitionError[light]
+	if !errors.As(err, &tErr) {
+		t.Fatalf("expected a *TypedTransitionError, got %T", err)
+	}
+	if tErr.Src != red || tErr.Dst != red {
+		t.Errorf("unexpected error states (%d -> %d)", tErr.Src, tErr.Dst)
+	}
+	var uErr *lfsm.TransitionError
+	if !errors.As(err, &uErr) {
+		t.Error("expected the underlying *TransitionError to be reachable")
+	}
+
+	fatalIfErr(t, m.Transition(green))
+	if m.Current() != green || m.State().Current() != uint32(green) {
+		t.Errorf("unexpected current state %d", m.Current())
+	}
+}
+
+func TestCallbacksCalledOncePerTransition(t *testing.T) {
+	var entered, transitions int32
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}},
+		lfsm.OnEnter(1, func(src, dst uint32) { atomic.AddInt32(&entered, 1) }),
+		lfsm.OnTransition(func(src, dst uint32) { atomic.AddInt32(&transitions, 1) }),
+	)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.TransitionFrom(0, 1)
+		}()
+	}
+	wg.Wait()
+
+	if entered != 1 || transitions != 1 {
+		t.Errorf("expected a single callback call, got %d enter and %d transition calls", entered, transitions)
+	}
+}
+
+func TestGuardRejectedErrorWrapsCause(t *testing.T) {
+	cause := errors.New("out of stock")
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}},
+		lfsm.Guard(0, 1, func(src, dst uint32) error { return cause }),
+	)
+
+	err := s.Transition(1)
+	var gErr *lfsm.GuardRejectedError
+	if !errors.As(err, &gErr) {
+		t.Fatalf("expected a *GuardRejectedError, got %T", err)
+	}
+	if gErr.Src != 0 || gErr.Dst != 1 {
+		t.Errorf("unexpected error states (%d -> %d)", gErr.Src, gErr.Dst)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("expected the guard error to be wrapped")
+	}
+	if s.Current() != 0 {
+		t.Errorf("expected the state to remain 0, got %d", s.Current())
+	}
+}
+
+func TestTransitionWait(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}})
+	counter := 0
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logErr(t, s.TransitionWait(context.Background(), 1))
+				counter++
+				logErr(t, s.Transition(0))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if counter != 100*100 {
+		t.Errorf("counter is %d instead of %d", counter, 100*100)
+	}
+}
+
+func TestWaitForCanceled(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	v, err := s.WaitFor(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if v != 0 {
+		t.Errorf("expected the current state 0, got %d", v)
+	}
+}
+
+func TestSubscribeCoalesce(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}}, lfsm.SlowSubscribers(lfsm.CoalesceChanges))
+	changes, cancel := s.Subscribe(0)
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		fatalIfErr(t, s.Transition(uint32((i+1)%2)))
+	}
+
+	c := <-changes
+	if c.Seq != 10 || c.Dst != 0 {
+		t.Errorf("expected only the latest change, got #%d (%d -> %d)", c.Seq, c.Src, c.Dst)
+	}
+}
+
+func TestSubscribeBlockUnsubscribe(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}}, lfsm.SlowSubscribers(lfsm.BlockOnChanges))
+	_, cancel := s.Subscribe(0)
+
+	time.AfterFunc(time.Millisecond, cancel)
+	fatalIfErr(t, s.Transition(1))
+	fatalIfErr(t, s.Transition(0))
+}
+
+func TestFireFailedTransitionHasEvent(t *testing.T) {
+	var s *lfsm.State
+	s = lfsm.NewState(lfsm.Constraints{}, lfsm.Events{0: {"pay": 1, "cancel": 2}},
+		lfsm.Guard(0, 1, func(src, dst uint32) error {
+			// Simulates a concurrent cancel that wins the race.
+			return s.Fire("cancel")
+		}),
+	)
+
+	var tErr *lfsm.TransitionError
+	err := s.Fire("pay")
+	if !errors.As(err, &tErr) || tErr.Event != "pay" || tErr.Src != 0 || tErr.Dst != 1 {
+		t.Errorf("expected a failed transition error of the pay event, got %v", err)
+	}
+	if s.Current() != 2 {
+		t.Errorf("expected the state to be 2, got %d", s.Current())
+	}
+
+	err = s.Fire("refund")
+	if !errors.As(err, &tErr) || tErr.Event != "refund" {
+		t.Errorf("expected an undefined event error, got %v", err)
+	}
+}
+
+func TestSubstatesInheritTransitions(t *testing.T) {
+	const (
+		root uint32 = iota
+		mid
+		leaf
+		other
+	)
+	var guarded []uint32
+	s := lfsm.NewState(
+		lfsm.Constraints{root: {other}, other: {leaf}},
+		lfsm.InitialState(other),
+		lfsm.Substates(root, mid),
+		lfsm.Substates(mid, leaf),
+		lfsm.Guard(leaf, other, func(src, dst uint32) error { guarded = append(guarded, leaf); return nil }),
+		lfsm.Guard(root, other, func(src, dst uint32) error { guarded = append(guarded, root); return nil }),
+	)
+
+	fatalIfErr(t, s.Transition(leaf))
+	if !s.InState(root) || !s.InState(mid) || !s.InState(leaf) || s.InState(other) {
+		t.Error("unexpected ancestry of the leaf state")
+	}
+	if err := s.Transition(mid); err == nil {
+		t.Error("Invalid transition error expected.")
+	}
+
+	fatalIfErr(t, s.Transition(other))
+	if len(guarded) != 2 || guarded[0] != leaf || guarded[1] != root {
+		t.Errorf("expected the leaf and root guards to be evaluated in order, got %v", guarded)
+	}
+}
+
+func TestSubstatesClusters(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {}}, lfsm.Substates(0, 1, 2), lfsm.Substates(2, 3))
+	want := `subgraph cluster_n0{label="0";n0[label="0",style=filled];n1[label="1"];` +
+		`subgraph cluster_n2{label="2";n2[label="2"];n3[label="3"];}}`
+	if !strings.Contains(s.String(), want) {
+		t.Errorf("expected nested clusters, got %s", s)
+	}
+}
+
+func TestRegionsConcurrentTransitionRegion(t *testing.T) {
+	rs := lfsm.NewRegions(
+		lfsm.Region{Constraints: newBigConstraints(10)},
+		lfsm.Region{Constraints: newBigConstraints(1000)},
+		lfsm.Region{Constraints: newBigConstraints(3)},
+	)
+
+	wg := new(sync.WaitGroup)
+	for i, size := range []uint32{10, 1000, 3} {
+		wg.Add(1)
+		go func(i int, size uint32) {
+			defer wg.Done()
+			for j := uint32(1); j <= 10*size; j++ {
+				logErr(t, rs.TransitionRegion(i, j%size))
+			}
+		}(i, size)
+	}
+	wg.Wait()
+
+	if states := rs.Current(); states[0] != 0 || states[1] != 0 || states[2] != 0 {
+		t.Errorf("expected all regions to return to 0, got %v", states)
+	}
+	if err := rs.Transition([]uint32{2, 0, 0}); err == nil {
+		t.Error("Invalid transition error expected.")
+	}
+	fatalIfErr(t, rs.Transition([]uint32{1, 1, 1}))
+}
+
+func TestTransitionFromSnapshotABA(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}})
+	snap := s.Snapshot()
+	fatalIfErr(t, s.Transition(1))
+	fatalIfErr(t, s.Transition(0))
+
+	var sErr *lfsm.StaleSnapshotError
+	if err := s.TransitionFromSnapshot(snap, 1); !errors.As(err, &sErr) {
+		t.Fatalf("expected a *StaleSnapshotError, got %v", err)
+	}
+	if sErr.Observed != (lfsm.Snapshot{State: 0, Generation: 2}) {
+		t.Errorf("unexpected observed snapshot %+v", sErr.Observed)
+	}
+	if err := s.TransitionFromSnapshot(snap, 0); err == nil {
+		t.Error("Invalid transition error expected.")
+	}
+
+	// A plain TransitionFrom only compares the state value.
+	fatalIfErr(t, s.TransitionFrom(snap.State, 1))
+}
+
+func TestAnalyzeBigState(t *testing.T) {
+	a := lfsm.Analyze(newBigConstraints(1000), 0)
+	if len(a.Reachable) != 1000 || len(a.Unreachable) != 0 || len(a.Terminal) != 0 || len(a.Undeclared) != 0 {
+		t.Errorf("unexpected analysis of a cyclic machine: %d reachable, %d unreachable, %d terminal, %d undeclared",
+			len(a.Reachable), len(a.Unreachable), len(a.Terminal), len(a.Undeclared))
+	}
+	if len(a.Components) != 1 || len(a.Components[0]) != 1000 {
+		t.Errorf("expected a single strongly connected component, got %d", len(a.Components))
+	}
+}
+
+func TestAnalyzeUndeclaredInitialState(t *testing.T) {
+	a := lfsm.Analyze(lfsm.Constraints{1: {0}}, 0)
+	if len(a.Undeclared) != 1 || a.Undeclared[0] != 0 {
+		t.Errorf("expected the initial state to be undeclared, got %v", a.Undeclared)
+	}
+	a = lfsm.Analyze(lfsm.Constraints{1: {2}}, 0)
+	if len(a.Undeclared) != 2 || a.Undeclared[0] != 0 || a.Undeclared[1] != 2 {
+		t.Errorf("expected the initial state without incoming transitions to be undeclared, got %v", a.Undeclared)
+	}
+}
+
+func TestNewStateE(t *testing.T) {
+	s, err := lfsm.NewStateE(lfsm.Constraints{0: {1}, 1: {0}}, lfsm.StateNames{0: "a", 1: "b"}, lfsm.InitialState(1))
+	fatalIfErr(t, err)
+	if s.Current() != 1 {
+		t.Errorf("expected the initial state 1, got %d", s.Current())
+	}
+
+	_, err = lfsm.NewStateE(lfsm.Constraints{0: {1}}, lfsm.Substates(0, 7))
+	var cErr *lfsm.ConfigError
+	if !errors.As(err, &cErr) || len(cErr.Problems) != 1 || !errors.Is(err, lfsm.ErrUnknownSubstate) {
+		t.Errorf("expected a single unknown substate problem, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustNewState to panic")
+		}
+	}()
+	lfsm.MustNewState(lfsm.Constraints{0: {1}}, lfsm.InitialState(5))
+}
+
+func TestFinalInitialStateIsDone(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}}, lfsm.FinalStates(0))
+	select {
+	case <-s.Done():
+	default:
+		t.Error("expected Done to be closed")
+	}
+
+	var fErr *lfsm.FinalStateError
+	if err := s.Transition(1); !errors.As(err, &fErr) {
+		t.Errorf("expected a *FinalStateError, got %v", err)
+	}
+	if err := s.TransitionWait(context.Background(), 1); !errors.As(err, &fErr) {
+		t.Errorf("expected TransitionWait to return a *FinalStateError, got %v", err)
+	}
+}
+
+func TestMarshalBinaryRoundTrip(t *testing.T) {
+	s := newBigState(100)
+	fatalIfErr(t, s.Transition(0))
+	fatalIfErr(t, s.Transition(1))
+	data, err := s.MarshalBinary()
+	fatalIfErr(t, err)
+
+	restored := newBigState(100)
+	fatalIfErr(t, restored.UnmarshalBinary(data))
+	if restored.Current() != 1 {
+		t.Errorf("expected the restored state 1, got %d", restored.Current())
+	}
+	if !strings.Contains(restored.String(), "s->n99;") {
+		t.Error("expected the initial state to be preserved")
+	}
+
+	text, err := s.MarshalText()
+	fatalIfErr(t, err)
+	fatalIfErr(t, restored.UnmarshalText(text))
+	if err := restored.UnmarshalBinary([]byte{0, 0, 1, 0}); !errors.Is(err, lfsm.ErrUnknownState) {
+		t.Errorf("expected an unknown state error, got %v", err)
+	}
+}
+
+func TestDefinitionRoundTrip(t *testing.T) {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1, 2}, 1: {0}},
+		lfsm.InitialState(1),
+		lfsm.StateNames{0: "a", 1: "b"},
+		lfsm.Events{1: {"go": 2}},
+		lfsm.Substates(0, 1),
+		lfsm.FinalStates(2),
+	)
+	data, err := json.Marshal(s.Definition())
+	fatalIfErr(t, err)
+
+	loaded, err := lfsm.LoadDefinition(bytes.NewReader(data))
+	fatalIfErr(t, err)
+	again, err := json.Marshal(loaded.Definition())
+	fatalIfErr(t, err)
+	if !bytes.Equal(data, again) {
+		t.Errorf("definition changed after a round trip:\n%s\n%s", data, again)
+	}
+	if !loaded.InState(0) {
+		t.Error("expected the loaded state machine to start at a substate of a")
+	}
+
+	if _, err := lfsm.LoadDefinition(strings.NewReader(`{"transitions": {"a": ["b"]}}`)); !errors.Is(err, lfsm.ErrUnknownState) {
+		t.Errorf("expected an unknown state error, got %v", err)
+	}
+}
+
+func TestWriteDOTDeterministic(t *testing.T) {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1, 2}, 1: {2}, 2: {0}},
+		lfsm.StateNames{0: "a", 1: "b", 2: "c"},
+		lfsm.Events{1: {"go": 2}},
+		lfsm.Substates(0, 1),
+		lfsm.FinalStates(3),
+	)
+	want := `digraph g{s[label="",shape=none,height=.0,width=.0];s->n0;` +
+		`subgraph cluster_n0{label="a";n0[label="a",style=filled];n1[label="b"];}` +
+		`n2[label="c"];n3[label="3",shape=doublecircle];` +
+		`n0->n1;n0->n2;n1->n2[label="go"];n2->n0;}`
+	for i := 0; i < 10; i++ {
+		buf := &bytes.Buffer{}
+		fatalIfErr(t, s.WriteDOT(buf, lfsm.DOTOptions{EdgeLabels: true}))
+		if buf.String() != want {
+			t.Fatalf("unexpected DOT output:\n%s\n%s", buf, want)
+		}
+	}
+}
+
+func TestWriteDOTOptions(t *testing.T) {
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1, 2}, 1: {0}, 2: {0}},
+		lfsm.StateNames{0: `say "hi"`, 1: `back\slash`},
+		lfsm.Events{0: {"go": 1}},
+	)
+	buf := &bytes.Buffer{}
+	fatalIfErr(t, s.WriteDOT(buf, lfsm.DOTOptions{
+		RankDir:     "LR",
+		StateStyles: map[uint32]string{1: "color=red"},
+		HideUnnamed: true,
+	}))
+	want := `digraph g{rankdir="LR";s[label="",shape=none,height=.0,width=.0];s->n0;` +
+		`n0[label="say \"hi\"",style=filled];n1[label="back\\slash",color=red];n0->n1;n1->n0;}`
+	if buf.String() != want {
+		t.Errorf("unexpected DOT output:\n%s\n%s", buf, want)
+	}
+}
+
+func TestHistoryConcurrent(t *testing.T) {
+	s := lfsm.NewState(newBigConstraints(2), lfsm.History(16))
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logErr(t, s.TransitionWait(context.Background(), 1))
+				logErr(t, s.Transition(0))
+				_ = s.History()
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries := s.History()
+	if len(entries) != 16 {
+		t.Fatalf("expected 16 entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Generation != uint32(8*100*2-16+i+1) {
+			t.Errorf("unexpected generation %d at %d", e.Generation, i)
+		}
+		if i > 0 && e.Src != entries[i-1].Dst {
+			t.Errorf("entry %d doesn't continue the previous entry (%d -> %d)", i, e.Src, e.Dst)
+		}
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	jw := lfsm.NewJournalWriter(buf)
+	s := lfsm.NewState(newBigConstraints(2), lfsm.Journal(jw))
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logErr(t, s.TransitionWait(context.Background(), 1))
+				logErr(t, s.Transition(0))
+			}
+		}()
+	}
+	wg.Wait()
+	fatalIfErr(t, s.Transition(1))
+	fatalIfErr(t, jw.Err())
+
+	restored := lfsm.NewState(newBigConstraints(2), lfsm.Journal(lfsm.NewJournalWriter(buf)))
+	fatalIfErr(t, restored.Replay(bytes.NewReader(buf.Bytes())))
+	if restored.Snapshot() != s.Snapshot() {
+		t.Errorf("expected the replayed snapshot %+v, got %+v", s.Snapshot(), restored.Snapshot())
+	}
+
+	// Journaling continues after the replayed entries.
+	fatalIfErr(t, restored.Transition(0))
+	again := lfsm.NewState(newBigConstraints(2))
+	fatalIfErr(t, again.Replay(buf))
+	if again.Snapshot() != restored.Snapshot() {
+		t.Errorf("expected the replayed snapshot %+v, got %+v", restored.Snapshot(), again.Snapshot())
+	}
+
+	invalid := strings.NewReader(`{"src":0,"dst":1,"gen":1}` + "\n" + `{"src":1,"dst":1,"gen":2}`)
+	var tErr *lfsm.TransitionError
+	if err := lfsm.NewState(newBigConstraints(2)).Replay(invalid); !errors.As(err, &tErr) {
+		t.Errorf("expected an invalid transition error, got %v", err)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	names := lfsm.StateNames{0: "idle", 1: "busy"}
+	m := lfsm.NewPrometheusMetrics("", names)
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}}, names, lfsm.Instrument(m))
+
+	fatalIfErr(t, s.Transition(1))
+	_ = s.Transition(1)
+	_ = s.TransitionFrom(0, 1)
+	fatalIfErr(t, s.Transition(0))
+
+	buf := &bytes.Buffer{}
+	fatalIfErr(t, m.WritePrometheus(buf))
+	for _, want := range []string{
+		"# TYPE lfsm_transitions_total counter\n",
+		`lfsm_transitions_total{src="idle",dst="busy"} 1` + "\n",
+		`lfsm_transitions_total{src="busy",dst="idle"} 1` + "\n",
+		`lfsm_transition_attempts_total{outcome="success"} 2` + "\n",
+		`lfsm_transition_attempts_total{outcome="invalid"} 1` + "\n",
+		`lfsm_transition_attempts_total{outcome="failed"} 1` + "\n",
+		`lfsm_state_duration_seconds_count{state="idle"} 1` + "\n",
+		`lfsm_state_duration_seconds_count{state="busy"} 1` + "\n",
+		`lfsm_state{state="idle"} 1` + "\n",
+		`lfsm_state{state="busy"} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected the metrics to contain %q:\n%s", want, buf)
+		}
+	}
+}
+
+type requestKey struct{}
+
+type recordingTracer struct {
+	spans []string
+}
+
+func (rt *recordingTracer) StartTransition(ctx context.Context, src, dst uint32) func(lfsm.Outcome, error) {
+	return func(outcome lfsm.Outcome, err error) {
+		rt.spans = append(rt.spans, fmt.Sprintf("%v: %d -> %d %s", ctx.Value(requestKey{}), src, dst, outcome))
+	}
+}
+
+func TestTracer(t *testing.T) {
+	rt := &recordingTracer{}
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {0}}, lfsm.Trace(rt))
+	ctx := context.WithValue(context.Background(), requestKey{}, "req-1")
+
+	fatalIfErr(t, s.TransitionCtx(ctx, 1))
+	_ = s.TransitionFromCtx(ctx, 0, 1)
+	_ = s.Transition(1)
+	fatalIfErr(t, s.TransitionWait(ctx, 0))
+
+	want := []string{"req-1: 0 -> 1 success", "req-1: 0 -> 1 failed", "<nil>: 1 -> 1 invalid", "req-1: 1 -> 0 success"}
+	if strings.Join(rt.spans, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected spans %q", rt.spans)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	s := lfsm.NewState(lfsm.Constraints{0: {1}}, lfsm.StateNames{0: "off", 1: "on"}, lfsm.Logger(slog.New(h)), lfsm.LogLevels(slog.LevelDebug, slog.LevelError))
+	logErr(t, s.TransitionTagged(1, "boot"))
+	if err := s.TransitionFrom(0, 1); err == nil {
+		t.Fatal("expected a failed transition")
+	}
+
+	var recs []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec map[string]any
+		fatalIfErr(t, dec.Decode(&rec))
+		recs = append(recs, rec)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if recs[0]["level"] != "DEBUG" || recs[0]["outcome"] != "success" || recs[0]["src_name"] != "off" ||
+		recs[0]["dst_name"] != "on" || recs[0]["tag"] != "boot" || recs[0]["error"] != nil {
+		t.Errorf("unexpected success record: %v", recs[0])
+	}
+	if recs[1]["level"] != "ERROR" || recs[1]["outcome"] != "failed" || recs[1]["error_kind"] != "failed" ||
+		recs[1]["error"] == nil {
+		t.Errorf("unexpected failure record: %v", recs[1])
+	}
+}
+
+func TestLogValue(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}}, lfsm.StateNames{0: "off", 1: "on"})
+	logErr(t, s.Transition(1))
+	v := s.LogValue()
+	if got := v.String(); got != "[state=1 name=on generation=1]" {
+		t.Errorf("unexpected State.LogValue: %s", got)
+	}
+
+	var tErr *lfsm.TransitionError
+	if !errors.As(s.TransitionFrom(0, 1), &tErr) {
+		t.Fatal("expected a *lfsm.TransitionError")
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("failed", "err", tErr)
+	if !strings.Contains(buf.String(), "err.src_name=off err.dst_name=on") {
+		t.Errorf("unexpected lfsm.TransitionError.LogValue: %s", buf.String())
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	errClosed := errors.New("closed")
+	s := lfsm.NewState(
+		lfsm.Constraints{0: {1, 2}, 1: {0, 2}},
+		lfsm.StateNames{0: "a", 1: "b", 2: "c"},
+		lfsm.Events{0: {"go": 1}, 1: {"back": 0}},
+		lfsm.Guard(1, 0, func(_, _ uint32) error { return errClosed }),
+		lfsm.FinalStates(2),
+	)
+
+	err := s.TransitionFrom(1, 2)
+	var tErr *lfsm.TransitionError
+	if !errors.Is(err, lfsm.ErrStaleSource) || !errors.As(err, &tErr) {
+		t.Fatalf("expected a stale source error, got %v", err)
+	}
+	if v, ok := tErr.ObservedState(); !ok || v != 0 || tErr.ObservedName() != "a" || tErr.DstName() != "c" {
+		t.Errorf("unexpected error fields: %+v", tErr)
+	}
+	if errors.Is(err, lfsm.ErrInvalidTransition) {
+		t.Errorf("stale source error matches ErrInvalidTransition")
+	}
+
+	if err := s.Transition(0); !errors.Is(err, lfsm.ErrInvalidTransition) {
+		t.Errorf("expected an invalid transition error, got %v", err)
+	} else if _, ok := err.(*lfsm.TransitionError).ObservedState(); ok {
+		t.Errorf("expected no observed state for an invalid transition")
+	}
+	if err := s.Fire("back"); !errors.Is(err, lfsm.ErrUndefinedEvent) || !errors.Is(err, lfsm.ErrInvalidTransition) {
+		t.Errorf("expected an undefined event error, got %v", err)
+	}
+
+	fatalIfErr(t, s.Fire("go"))
+	err = s.Fire("back")
+	var gErr *lfsm.GuardRejectedError
+	if !errors.Is(err, lfsm.ErrGuardRejected) || !errors.Is(err, errClosed) || !errors.As(err, &gErr) ||
+		!errors.As(err, &tErr) || tErr.Event != "back" {
+		t.Errorf("expected a wrapped guard rejection, got %v", err)
+	}
+
+	snap := s.Snapshot()
+	fatalIfErr(t, s.Transition(2))
+	if err := s.Transition(0); !errors.Is(err, lfsm.ErrFinalState) {
+		t.Errorf("expected a final state error, got %v", err)
+	}
+	if err := s.TransitionFromSnapshot(snap, 2); !errors.Is(err, lfsm.ErrStaleSnapshot) {
+		t.Errorf("expected a stale snapshot error, got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	const (
+		idle uint32 = iota
+		paying
+		finalizing
+	)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := lfsm.NewManualClock(start)
+	s := lfsm.NewState(
+		lfsm.Constraints{idle: {paying}, paying: {idle, finalizing}, finalizing: {idle}},
+		lfsm.Timeout(paying, 30*time.Second, finalizing),
+		lfsm.Timeout(finalizing, time.Second, idle),
+		lfsm.History(8),
+		lfsm.WithClock(clock),
+	)
+
+	fatalIfErr(t, s.Transition(paying))
+	clock.Advance(20 * time.Second)
+	fatalIfErr(t, s.Transition(idle))
+	fatalIfErr(t, s.Transition(paying))
+	clock.Advance(20 * time.Second)
+	if got := s.Current(); got != paying {
+		t.Fatalf("timer of a previous visit fired, state is %d", got)
+	}
+
+	clock.Advance(11 * time.Second)
+	if got := s.Current(); got != idle {
+		t.Fatalf("expected the chained timeouts to reach idle, state is %d", got)
+	}
+	entries := s.History()
+	if len(entries) != 5 {
+		t.Fatalf("got %d history entries, want 5", len(entries))
+	}
+	if e := entries[3]; e.Dst != finalizing || e.Tag != "timeout" || !e.Time.Equal(start.Add(50*time.Second)) {
+		t.Errorf("unexpected timeout entry: %+v", e)
+	}
+	if e := entries[4]; e.Dst != idle || e.Tag != "timeout" || !e.Time.Equal(start.Add(51*time.Second)) {
+		t.Errorf("unexpected timeout entry: %+v", e)
+	}
+}
+
+func TestTimeoutInitialState(t *testing.T) {
+	clock := lfsm.NewManualClock(time.Time{})
+	s := lfsm.NewState(lfsm.Constraints{0: {1}}, lfsm.Timeout(0, time.Minute, 1), lfsm.WithClock(clock))
+	clock.Advance(time.Minute)
+	if got := s.Current(); got != 1 {
+		t.Errorf("expected the initial state to time out, state is %d", got)
+	}
+}
+
+func TestInvalidTimeout(t *testing.T) {
+	_, err := lfsm.NewStateE(lfsm.Constraints{0: {1}}, lfsm.Timeout(1, time.Second, 0))
+	if !errors.Is(err, lfsm.ErrInvalidTimeout) {
+		t.Errorf("expected an invalid timeout error, got %v", err)
+	}
+}
+
+func TestInvalidConfigTimeoutNeverFires(t *testing.T) {
+	clock := lfsm.NewManualClock(time.Time{})
+	var fired atomic.Bool
+	_, err := lfsm.NewStateE(
+		lfsm.Constraints{0: {1}},
+		lfsm.StateNames{7: "unknown"},
+		lfsm.Timeout(0, time.Second, 1),
+		lfsm.OnTransition(func(_, _ uint32) { fired.Store(true) }),
+		lfsm.WithClock(clock),
+	)
+	if !errors.Is(err, lfsm.ErrUnknownNamedState) {
+		t.Fatalf("expected an unknown named state error, got %v", err)
+	}
+	clock.Advance(time.Hour)
+	if fired.Load() {
+		t.Error("the timeout of a rejected state machine fired")
+	}
+}
+
+func TestReplayArmsTimeout(t *testing.T) {
+	clock := lfsm.NewManualClock(time.Time{})
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {2}}, lfsm.Timeout(1, time.Second, 2), lfsm.WithClock(clock))
+	fatalIfErr(t, s.Replay(strings.NewReader(`{"src":0,"dst":1,"gen":1}`+"\n")))
+	clock.Advance(time.Hour)
+	if got := s.Current(); got != 2 {
+		t.Errorf("expected the replayed state to time out, state is %d", got)
+	}
+}
+
+func TestJournalRestore(t *testing.T) {
+	buf := &bytes.Buffer{}
+	jw := lfsm.NewJournalWriter(buf)
+	s := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {2}}, lfsm.Journal(jw))
+	fatalIfErr(t, s.Transition(1))
+	fatalIfErr(t, s.Restore(0))
+	fatalIfErr(t, s.Transition(1))
+	fatalIfErr(t, jw.Err())
+
+	replayed := lfsm.NewState(lfsm.Constraints{0: {1}, 1: {2}})
+	fatalIfErr(t, replayed.Replay(bytes.NewReader(buf.Bytes())))
+	if replayed.Snapshot() != s.Snapshot() {
+		t.Errorf("expected the replayed snapshot %+v, got %+v", s.Snapshot(), replayed.Snapshot())
+	}
+
+	unknown := strings.NewReader(`{"src":0,"dst":7,"gen":1,"restored":true}` + "\n")
+	if err := lfsm.NewState(lfsm.Constraints{0: {1}}).Replay(unknown); !errors.Is(err, lfsm.ErrUnknownState) {
+		t.Errorf("expected an unknown state error, got %v", err)
+	}
+}
+
+func TestMetricsNestedTransition(t *testing.T) {
+	clock := lfsm.NewManualClock(time.Time{})
+	m := lfsm.NewPrometheusMetrics("", nil)
+	var s *lfsm.State
+	s = lfsm.NewState(
+		lfsm.Constraints{0: {1}, 1: {2}},
+		lfsm.Instrument(m),
+		lfsm.WithClock(clock),
+		lfsm.OnEnter(1, func(_, _ uint32) {
+			clock.Advance(time.Second)
+			logErr(t, s.Transition(2))
+		}),
+	)
+	clock.Advance(time.Minute)
+	fatalIfErr(t, s.Transition(1))
+
+	buf := &bytes.Buffer{}
+	fatalIfErr(t, m.WritePrometheus(buf))
+	for _, want := range []string{
+		`lfsm_state{state="2"} 1` + "\n",
+		`lfsm_state{state="1"} 0` + "\n",
+		`lfsm_state_duration_seconds_sum{state="0"} 60` + "\n",
+		`lfsm_state_duration_seconds_sum{state="1"} 1` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected the metrics to contain %q:\n%s", want, buf)
+		}
+	}
+}
+
+func TestSubscribeSeq(t *testing.T) {
+	var s *lfsm.State
+	s = lfsm.NewState(
+		lfsm.Constraints{0: {1}, 1: {2}},
+		lfsm.OnEnter(1, func(_, _ uint32) { logErr(t, s.Transition(2)) }),
+	)
+	changes, cancel := s.Subscribe(4)
+	defer cancel()
+	fatalIfErr(t, s.Transition(1))
+	fatalIfErr(t, s.Restore(0))
+	fatalIfErr(t, s.Transition(1))
+
+	// The nested transition is delivered first, but its Seq reveals that it happened second.
+	first, second := <-changes, <-changes
+	if first.Seq != 2 || first.Generation != 2 || first.Dst != 2 || second.Seq != 1 || second.Dst != 1 {
+		t.Errorf("unexpected changes: #%d %d->%d, #%d %d->%d",
+			first.Seq, first.Src, first.Dst, second.Seq, second.Src, second.Dst)
+	}
+	// Restore starts a new generation, but it is not a change, so Seq doesn't skip it.
+	third := <-changes
+	if third.Seq != 4 || third.Generation != 5 {
+		t.Errorf("expected #4 at generation 5, got #%d at generation %d", third.Seq, third.Generation)
+	}
+}
+
+func TestUnmarshalJSONNull(t *testing.T) {
+	s := lfsm.NewState(lfsm.Constraints{0: {1}}, lfsm.InitialState(1))
+	fatalIfErr(t, json.Unmarshal([]byte("null"), s))
+	if got := s.Snapshot(); got != (lfsm.Snapshot{State: 1}) {
+		t.Errorf("expected null to be a no-op, got %+v", got)
+	}
+}
+
+func TestState64AndRegionsString(t *testing.T) {
+	s := lfsm.NewState64(lfsm.Constraints64{2: {0, 1}, 0: {2}}, lfsm.StateNames64{2: `"c"`, 1: "b", 0: "a"})
+	want := `digraph g{s[label="",shape=none,height=.0,width=.0];s->n0;` +
+		`n0[label="a",style=filled];n1[label="b"];n2[label="\"c\""];n0->n2;n2->n0;n2->n1;}`
+	for i := 0; i < 10; i++ {
+		if got := s.String(); got != want {
+			t.Fatalf("unexpected State64 graph:\n%s\nwant:\n%s", got, want)
+		}
+	}
+
+	rs := lfsm.NewRegions(lfsm.Region{
+		Name:        `"r"`,
+		Constraints: lfsm.Constraints{1: {0, 2}, 0: {1}},
+		StateNames:  lfsm.StateNames{1: "b", 0: `"a"`},
+	})
+	want = `digraph g{subgraph cluster_r0{label="\"r\"";r0n0[label="\"a\"",style=filled];r0n1[label="b"];` +
+		`r0n0->r0n1;r0n1->r0n0;r0n1->r0n2;}}`
+	for i := 0; i < 10; i++ {
+		if got := rs.String(); got != want {
+			t.Fatalf("unexpected Regions graph:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func TestState64Errors(t *testing.T) {
+	s := lfsm.NewState64(lfsm.Constraints64{1 << 40: {1 << 41}}, lfsm.InitialState64(1<<40), lfsm.StateName64(1<<40, "big"))
+
+	err := s.TransitionFrom(1<<41, 1<<40)
+	if !errors.Is(err, lfsm.ErrInvalidTransition) {
+		t.Errorf("expected an invalid transition error, got %v", err)
+	}
+	fatalIfErr(t, s.TransitionFrom(1<<40, 1<<41))
+
+	s2 := lfsm.NewState64(lfsm.Constraints64{0: {1}, 2: {1}}, lfsm.InitialState64(2))
+	err = s2.TransitionFrom(0, 1)
+	var tErr *lfsm.TransitionError64
+	if !errors.Is(err, lfsm.ErrStaleSource) || !errors.As(err, &tErr) {
+		t.Fatalf("expected a stale source error, got %v", err)
+	}
+	if v, ok := tErr.ObservedState(); !ok || v != 2 {
+		t.Errorf("expected the observed state 2, got %d (%t)", v, ok)
+	}
+}
+
+func TestDefinitionDecodedElsewhere(t *testing.T) {
+	// A definition that was decoded from YAML or TOML by another package.
+	d := &lfsm.Definition{
+		States:      []lfsm.StateDefinition{{ID: 0, Name: "off"}, {ID: 1, Name: "on"}},
+		Initial:     "on",
+		Transitions: map[string][]string{"off": {"on"}, "on": {"off"}},
+	}
+	s, err := d.NewState()
+	fatalIfErr(t, err)
+	if s.CurrentName() != "on" {
+		t.Errorf("expected the initial state on, got %s", s.CurrentName())
+	}
+}
+
+func TestDefinitionRejectsAmbiguity(t *testing.T) {
+	d := &lfsm.Definition{
+		States:      []lfsm.StateDefinition{{ID: 0, Name: "off"}, {ID: 0, Name: "on"}},
+		Transitions: map[string][]string{"off": {"on"}},
+	}
+	if _, err := d.NewState(); !errors.Is(err, lfsm.ErrDuplicateStateID) {
+		t.Errorf("expected a duplicate state id error, got %v", err)
+	}
+
+	d = &lfsm.Definition{
+		States:      []lfsm.StateDefinition{{ID: 0, Name: "1"}},
+		Transitions: map[string][]string{"1": {"1"}},
+	}
+	if _, err := d.NewState(); !errors.Is(err, lfsm.ErrAmbiguousReference) {
+		t.Errorf("expected an ambiguous reference error, got %v", err)
+	}
+}
+
+// panickyMetrics panics the first time the state machine enters state 1.
+type panickyMetrics struct {
+	panicked bool
+}
+
+func (m *panickyMetrics) ObserveTransition(uint32, uint32, lfsm.Outcome) {}
+func (m *panickyMetrics) ObserveStateDuration(uint32, time.Duration)     {}
+func (m *panickyMetrics) SetState(v uint32) {
+	if v == 1 && !m.panicked {
+		m.panicked = true
+		panic("metrics failure")
+	}
+}
+
+type sliceSink struct {
+	entries []lfsm.HistoryEntry
+}
+
+func (s *sliceSink) Append(e lfsm.HistoryEntry) {
+	s.entries = append(s.entries, e)
+}
+
+func TestJournalSkipsMissingGeneration(t *testing.T) {
+	sink := &sliceSink{}
+	s := lfsm.NewState(newBigConstraints(2), lfsm.Journal(sink), lfsm.Instrument(&panickyMetrics{}))
+	func() {
+		defer func() { _ = recover() }()
+		_ = s.Transition(1)
+	}()
+	for i := 0; i < 1025; i++ {
+		fatalIfErr(t, s.Transition(uint32(i%2)))
+	}
+	if len(sink.entries) != 1025 || sink.entries[0].Generation != 2 {
+		t.Errorf("expected the 1025 entries after the missing generation, got %d", len(sink.entries))
+	}
+}
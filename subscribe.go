@@ -0,0 +1,147 @@
+package lfsm
+
+import (
+	"sync"
+	"time"
+)
+
+// Change describes a single successful transition, as delivered to subscribers.
+type Change struct {
+	Src, Dst         uint32
+	SrcName, DstName string
+
+	// Seq is a monotonically increasing sequence number of the transition, it is assigned when the transition
+	// commits, before any callback is called.
+	// Changes of concurrent transitions may be delivered out of order, Seq can be used to detect it.
+	Seq uint64
+	// Generation is the generation that the transition started, see Snapshot.
+	Generation uint32
+	Time       time.Time
+}
+
+// OverflowPolicy defines what happens when a subscriber doesn't keep up with the state changes.
+type OverflowPolicy int
+
+const (
+	// DropChanges drops new changes while the subscriber buffer is full (the default).
+	DropChanges OverflowPolicy = iota
+	// CoalesceChanges drops the oldest buffered changes, so the subscriber always receives the latest change.
+	CoalesceChanges
+	// BlockOnChanges blocks the transitioning goroutine until the subscriber receives the change, or unsubscribes.
+	// Note that a slow subscriber will slow down every successful transition.
+	BlockOnChanges
+)
+
+type subscriber struct {
+	ch     chan Change
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (sub *subscriber) send(c Change, policy OverflowPolicy) {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+	if sub.closed {
+		return
+	}
+	switch policy {
+	case CoalesceChanges:
+		for {
+			select {
+			case sub.ch <- c:
+				return
+			default:
+			}
+			select {
+			case <-sub.ch:
+			default:
+			}
+		}
+	case BlockOnChanges:
+		select {
+		case sub.ch <- c:
+		case <-sub.done:
+		}
+	default:
+		select {
+		case sub.ch <- c:
+		default:
+		}
+	}
+}
+
+func (sub *subscriber) close() {
+	close(sub.done)
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.closed = true
+	close(sub.ch)
+}
+
+// Subscribe returns a channel that receives a Change for every successful transition, and a function that cancels
+// the subscription and closes the channel.
+//
+// buf is the channel buffer size (CoalesceChanges always uses a buffer of at least 1). What happens when the buffer is
+// full is defined by the SlowSubscribers option.
+func (s *State) Subscribe(buf int) (<-chan Change, func()) {
+	if buf < 1 && s.overflow == CoalesceChanges {
+		buf = 1
+	}
+	sub := &subscriber{
+		ch:   make(chan Change, buf),
+		done: make(chan struct{}),
+	}
+
+	s.subMu.Lock()
+	subs := s.loadSubscribers()
+	subs = append(subs[:len(subs):len(subs)], sub)
+	s.subscribers.Store(&subs)
+	s.subMu.Unlock()
+
+	once := new(sync.Once)
+	return sub.ch, func() {
+		once.Do(func() {
+			s.subMu.Lock()
+			subs := s.loadSubscribers()
+			rest := make([]*subscriber, 0, len(subs))
+			for _, other := range subs {
+				if other != sub {
+					rest = append(rest, other)
+				}
+			}
+			s.subscribers.Store(&rest)
+			s.subMu.Unlock()
+			sub.close()
+		})
+	}
+}
+
+func (s *State) loadSubscribers() []*subscriber {
+	if subs := s.subscribers.Load(); subs != nil {
+		return *subs
+	}
+	return nil
+}
+
+// notify delivers the change to all the subscribers, word is the packed state word that the transition stored and
+// seq is its sequence number.
+// The subscribers list is copied on write, so reading it on the transition path is a single atomic load.
+func (s *State) notify(src, dst uint32, word, seq uint64) {
+	subs := s.loadSubscribers()
+	if len(subs) == 0 {
+		return
+	}
+	c := Change{
+		Src:        src,
+		Dst:        dst,
+		SrcName:    s.stateNames.find(src),
+		DstName:    s.stateNames.find(dst),
+		Seq:        seq,
+		Generation: uint32(word >> 32),
+		Time:       s.clock.Now(),
+	}
+	for _, sub := range subs {
+		sub.send(c, s.overflow)
+	}
+}
@@ -0,0 +1,62 @@
+package lfsm
+
+import (
+	"sync"
+	"time"
+)
+
+// Timeout transitions the state machine from v to dst, if it is still in v once d elapsed since it entered v.
+//
+// The timer is armed every time the state machine enters v (including the initial state), and it is disarmed once the
+// state machine leaves v. The transition is attempted using TransitionFromSnapshot with the snapshot of the entry, so
+// it never fires for a later visit of v, and it is recorded in the History with the "timeout" tag.
+// Only one timeout can be declared for every state, the last declaration wins.
+func Timeout(v uint32, d time.Duration, dst uint32) option {
+	return optionFn(func(s *State) {
+		if s.timeouts == nil {
+			s.timeouts = make(map[uint32]timeout)
+		}
+		s.timeouts[v] = timeout{d, dst}
+	})
+}
+
+type timeout struct {
+	d   time.Duration
+	dst uint32
+}
+
+// timers holds the timer of the current state, it is only used if timeouts are declared.
+type timers struct {
+	mu      sync.Mutex
+	started bool
+	gen     uint32
+	timer   Timer
+}
+
+// arm disarms the timer of the previous state and arms the timer of the state in word, if one was declared.
+// Commits may arrive out of order, so the timers of generations that were already replaced are ignored.
+func (s *State) arm(word uint64) {
+	if len(s.timeouts) == 0 {
+		return
+	}
+	snap := Snapshot{uint32(word), uint32(word >> 32)}
+
+	s.timers.mu.Lock()
+	defer s.timers.mu.Unlock()
+	if s.timers.started && int32(snap.Generation-s.timers.gen) <= 0 {
+		return
+	}
+	if s.timers.timer != nil {
+		s.timers.timer.Stop()
+		s.timers.timer = nil
+	}
+	s.timers.started = true
+	s.timers.gen = snap.Generation
+	t, ok := s.timeouts[snap.State]
+	if !ok {
+		return
+	}
+	s.timers.timer = s.clock.AfterFunc(t.d, func() {
+		_ = s.transitionFromSnapshot(snap, attempt{src: snap.State, dst: t.dst, tag: "timeout"})
+	})
+}
@@ -0,0 +1,30 @@
+package lfsm
+
+import "context"
+
+// Tracer traces transition attempts, e.g. by starting an OpenTelemetry span for every attempt.
+type Tracer interface {
+	// StartTransition is called before every transition attempt from src to dst, with the context of the caller
+	// (see TransitionCtx), or with context.Background if the attempt has no context.
+	// The returned function is called once the attempt is over, with its outcome and error.
+	StartTransition(ctx context.Context, src, dst uint32) func(outcome Outcome, err error)
+}
+
+// Trace reports every transition attempt to t.
+func Trace(t Tracer) option {
+	return optionFn(func(s *State) {
+		s.tracer = t
+	})
+}
+
+// TransitionFromCtx is like TransitionFrom, but it passes ctx to the Tracer, so the transition is traced as part of
+// the caller request.
+func (s *State) TransitionFromCtx(ctx context.Context, src, dst uint32) error {
+	return s.transitionFrom(attempt{ctx: ctx, src: src, dst: dst})
+}
+
+// TransitionCtx is like Transition, but it passes ctx to the Tracer, so the transition is traced as part of the
+// caller request.
+func (s *State) TransitionCtx(ctx context.Context, dst uint32) error {
+	return s.TransitionFromCtx(ctx, s.Current(), dst)
+}
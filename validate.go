@@ -0,0 +1,137 @@
+package lfsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrUnknownInitialState reports an initial state that doesn't appear in the transitions.
+	ErrUnknownInitialState = errors.New("unknown initial state")
+	// ErrUnknownNamedState reports a state name for a state that doesn't appear in the transitions.
+	ErrUnknownNamedState = errors.New("name of unknown state")
+	// ErrUnknownSubstate reports a Substates declaration of a state that doesn't appear in the transitions.
+	ErrUnknownSubstate = errors.New("unknown substate")
+	// ErrUnknownFinalState reports a final state that doesn't appear in the transitions.
+	ErrUnknownFinalState = errors.New("unknown final state")
+	// ErrDuplicateTransition reports a destination that is listed more than once for the same source.
+	ErrDuplicateTransition = errors.New("duplicate transition")
+	// ErrDuplicateName reports a name that is shared by more than one state.
+	ErrDuplicateName = errors.New("duplicate state name")
+	// ErrInvalidTimeout reports a Timeout whose transition is not defined.
+	ErrInvalidTimeout = errors.New("invalid timeout")
+)
+
+// ConfigError lists every problem that was found in a state machine configuration by NewStateE.
+//
+// Every problem wraps one of the ErrUnknownInitialState, ErrUnknownNamedState, ErrUnknownSubstate,
+// ErrUnknownFinalState, ErrDuplicateTransition, ErrDuplicateName or ErrInvalidTimeout errors, so they can be matched with errors.Is.
+type ConfigError struct {
+	Problems []error
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(msgs, "; "))
+}
+func (e *ConfigError) Unwrap() []error {
+	return e.Problems
+}
+
+// NewStateE creates a new State Machine, like NewState, but it also validates the configuration.
+// Returns a *ConfigError that lists all the problems that were found.
+func NewStateE(m Constraints, opts ...option) (*State, error) {
+	s := newState(m, opts...)
+	if problems := s.validate(m); len(problems) > 0 {
+		return nil, &ConfigError{problems}
+	}
+	s.start()
+	return s, nil
+}
+
+// MustNewState is like NewStateE, but panics if the configuration is invalid.
+func MustNewState(m Constraints, opts ...option) *State {
+	s, err := NewStateE(m, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *State) validate(m Constraints) []error {
+	var problems []error
+
+	known := s.known()
+
+	if !known[s.initial] {
+		problems = append(problems, fmt.Errorf("%w %s", ErrUnknownInitialState, s.stateNames.find(s.initial)))
+	}
+
+	for _, src := range sortedKeys(m) {
+		listed := make(map[uint32]bool, len(m[src]))
+		for _, dst := range m[src] {
+			if listed[dst] {
+				problems = append(problems, fmt.Errorf("%w (%s -> %s)", ErrDuplicateTransition, s.stateNames.find(src), s.stateNames.find(dst)))
+			}
+			listed[dst] = true
+		}
+	}
+
+	named := make(map[string]uint32, len(s.stateNames))
+	for _, v := range sortedKeys(s.stateNames) {
+		name := s.stateNames[v]
+		if !known[v] {
+			problems = append(problems, fmt.Errorf("%w %d (%s)", ErrUnknownNamedState, v, name))
+		}
+		if other, ok := named[name]; ok {
+			problems = append(problems, fmt.Errorf("%w %s (%d and %d)", ErrDuplicateName, name, other, v))
+		}
+		named[name] = v
+	}
+
+	for _, child := range sortedKeys(s.parents) {
+		if !known[child] {
+			problems = append(problems, fmt.Errorf("%w %s of %s", ErrUnknownSubstate, s.stateNames.find(child), s.stateNames.find(s.parents[child])))
+		}
+	}
+
+	for _, v := range sortedKeys(s.final) {
+		if !known[v] {
+			problems = append(problems, fmt.Errorf("%w %s", ErrUnknownFinalState, s.stateNames.find(v)))
+		}
+	}
+
+	for _, v := range sortedKeys(s.timeouts) {
+		if _, ok := s.allowed(v, s.timeouts[v].dst); !ok {
+			problems = append(problems, fmt.Errorf("%w (%s -> %s)", ErrInvalidTimeout, s.stateNames.find(v), s.stateNames.find(s.timeouts[v].dst)))
+		}
+	}
+
+	return problems
+}
+
+// known returns every state that appears in the transitions, as a source or as a destination.
+func (s *State) known() map[uint32]bool {
+	known := make(map[uint32]bool, len(s.transitions))
+	for src, dsts := range s.transitions {
+		known[src] = true
+		for dst := range dsts {
+			known[dst] = true
+		}
+	}
+	return known
+}
+
+func sortedKeys[K uint32 | uint64, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -3,30 +3,126 @@ package lfsm
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"strings"
 )
 
-// String returns the Graphviz representation of this state machine.
+// String returns the Graphviz representation of this state machine, it is WriteDOT with the default DOTOptions.
+//
+// Note that the output differs from the original String: states and transitions are sorted, names are escaped, every
+// state is declared (unnamed states are labeled with their integer), final states are drawn as double circles and
+// substates are grouped in clusters. Code that compares the output as a string should be updated accordingly.
 //
 // See: https://www.graphviz.org/ & https://dreampuf.github.io/GraphvizOnline
 func (s *State) String() string {
 	buf := &bytes.Buffer{}
-	_,_ = fmt.Fprint(buf, "digraph g{")
-	_,_ = fmt.Fprintf(buf, `s[label="",shape=none,height=.0,width=.0];s->n%d;`, s.initial)
+	_ = s.WriteDOT(buf, DOTOptions{})
+	return buf.String()
+}
+
+// DOTOptions configures the Graphviz output of WriteDOT.
+type DOTOptions struct {
+	// RankDir sets the direction of the graph layout ("TB", "LR", "BT" or "RL"), Graphviz defaults to "TB".
+	RankDir string
+	// EdgeLabels labels the transitions with the names of the events that trigger them.
+	EdgeLabels bool
+	// StateStyles holds extra Graphviz node attributes per state, e.g. `color=red,shape=box`.
+	StateStyles map[uint32]string
+	// HideUnnamed omits the states that have no name, along with their transitions.
+	HideUnnamed bool
+}
+
+// WriteDOT writes the Graphviz representation of this state machine to w.
+//
+// The output is deterministic: states are sorted, and transitions are sorted by their source and destination.
+// The current state is filled, final states are drawn as double circles and substates are grouped in clusters.
+func (s *State) WriteDOT(w io.Writer, opts DOTOptions) error {
+	g := s.graph()
+	ew := &exportWriter{w: w}
+	hidden := func(v uint32) bool {
+		return opts.HideUnnamed && !g.node(v).named
+	}
+
+	ew.printf("digraph g{")
+	if opts.RankDir != "" {
+		ew.printf(`rankdir="%s";`, dotEscape(opts.RankDir))
+	}
+	if !hidden(g.initial) {
+		ew.printf(`s[label="",shape=none,height=.0,width=.0];s->n%d;`, g.initial)
+	}
+	var declare func(v uint32)
+	declare = func(v uint32) {
+		n := g.node(v)
+		if hidden(v) {
+			for _, child := range g.children[v] {
+				declare(child)
+			}
+			return
+		}
+		if _, ok := g.children[v]; ok {
+			ew.printf(`subgraph cluster_n%d{label="%s";`, v, dotEscape(n.name))
+			defer ew.printf("}")
+		}
+		ew.printf(`n%d[label="%s"`, v, dotEscape(n.name))
+		if n.current {
+			ew.printf(",style=filled")
+		}
+		if n.final {
+			ew.printf(",shape=doublecircle")
+		}
+		if style := opts.StateStyles[v]; style != "" {
+			ew.printf(",%s", style)
+		}
+		ew.printf("];")
+		for _, child := range g.children[v] {
+			declare(child)
+		}
+	}
+	for _, v := range g.roots() {
+		declare(v)
+	}
+	for _, e := range g.edges {
+		if hidden(e.src) || hidden(e.dst) {
+			continue
+		}
+		ew.printf("n%d->n%d", e.src, e.dst)
+		if opts.EdgeLabels && len(e.events) > 0 {
+			ew.printf(`[label="%s"]`, dotEscape(strings.Join(e.events, ",")))
+		}
+		ew.printf(";")
+	}
+	ew.printf("}")
+	return ew.err
+}
+
+// dotEscape escapes text for a Graphviz quoted string.
+func dotEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(text)
+}
+
+// String returns the Graphviz representation of this state machine.
+// Like WriteDOT, the output is deterministic: states and transitions are sorted.
+//
+// See: https://www.graphviz.org/ & https://dreampuf.github.io/GraphvizOnline
+func (s *State64) String() string {
+	buf := &bytes.Buffer{}
+	_, _ = fmt.Fprint(buf, "digraph g{")
+	_, _ = fmt.Fprintf(buf, `s[label="",shape=none,height=.0,width=.0];s->n%d;`, s.initial)
 
-	for v,name := range s.stateNames {
+	for _, v := range sortedKeys(s.stateNames) {
 		if v == s.Current() {
-			_,_ = fmt.Fprintf(buf, `n%d[label="%s",style=filled];`, v, name)
+			_, _ = fmt.Fprintf(buf, `n%d[label="%s",style=filled];`, v, dotEscape(s.stateNames[v]))
 		} else {
-			_,_ = fmt.Fprintf(buf, `n%d[label="%s"];`, v, name)
+			_, _ = fmt.Fprintf(buf, `n%d[label="%s"];`, v, dotEscape(s.stateNames[v]))
 		}
 	}
 
-	for src,dsts := range s.transitions {
-		for dst := range dsts {
-			_,_ = fmt.Fprintf(buf, "n%d->n%d;", src, dst)
+	for _, src := range sortedKeys(s.transitions) {
+		for _, dst := range sortedKeys(s.transitions[src]) {
+			_, _ = fmt.Fprintf(buf, "n%d->n%d;", src, dst)
 		}
 	}
 
-	_,_ = fmt.Fprint(buf, "}")
+	_, _ = fmt.Fprint(buf, "}")
 	return buf.String()
 }
@@ -0,0 +1,77 @@
+package lfsm
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// WaitFor blocks until the state machine reaches one of the dst states, or until ctx is done.
+// Returns the state that was observed, or the current state and the context error if ctx is done first.
+func (s *State) WaitFor(ctx context.Context, dst ...uint32) (uint32, error) {
+	return s.waitUntil(ctx, func(v uint32) bool {
+		for _, d := range dst {
+			if v == d {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WaitWhile blocks as long as the state machine is in state v, or until ctx is done.
+// Returns the state that was observed, or the current state and the context error if ctx is done first.
+func (s *State) WaitWhile(ctx context.Context, v uint32) (uint32, error) {
+	return s.waitUntil(ctx, func(c uint32) bool {
+		return c != v
+	})
+}
+
+// TransitionWait tries to change the state to dst until it succeeds, or until ctx is done.
+// Failed and invalid transitions are retried after the state machine leaves the state they were attempted from,
+// any other error (e.g. a rejecting Guard) is returned immediately.
+func (s *State) TransitionWait(ctx context.Context, dst uint32) error {
+	for {
+		src := s.Current()
+		err := s.transitionFrom(attempt{ctx: ctx, src: src, dst: dst})
+		var tErr *TransitionError
+		if err == nil || !errors.As(err, &tErr) {
+			return err
+		}
+		if _, err := s.WaitWhile(ctx, src); err != nil {
+			return err
+		}
+	}
+}
+
+// waitUntil parks the calling goroutine until cond reports true for the current state.
+//
+// Waiters register themselves before reading the state, and transitions read the waiters count after their
+// compare-and-swap, so either the waiter observes the new state or the transition wakes the waiter up.
+func (s *State) waitUntil(ctx context.Context, cond func(uint32) bool) (uint32, error) {
+	atomic.AddInt32(&s.waiters, 1)
+	defer atomic.AddInt32(&s.waiters, -1)
+	for {
+		changed := s.changed.Load().(chan struct{})
+		if v := s.Current(); cond(v) {
+			return v, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return s.Current(), ctx.Err()
+		}
+	}
+}
+
+// broadcast wakes up all the goroutines that are parked in waitUntil.
+// It costs a single atomic load when there are no waiters, so the transition path stays lock-free.
+func (s *State) broadcast() {
+	if atomic.LoadInt32(&s.waiters) == 0 {
+		return
+	}
+	changed := s.changed.Load()
+	if s.changed.CompareAndSwap(changed, make(chan struct{})) {
+		close(changed.(chan struct{}))
+	}
+}